@@ -0,0 +1,333 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// aggregatePageSize is the number of search hits loaded at once by *Collection.Aggregate
+const aggregatePageSize = 1000
+
+// Those are the operators of the accumulators
+const (
+	// AggregateCount counts the documents of the group, or the ones having the field if Accumulator.Field is set
+	AggregateCount AggregateOperator = "count"
+	// AggregateSum adds up the numbers of the field
+	AggregateSum AggregateOperator = "sum"
+	// AggregateAvg is the average of the numbers of the field
+	AggregateAvg AggregateOperator = "avg"
+	// AggregateMin is the smallest number of the field
+	AggregateMin AggregateOperator = "min"
+	// AggregateMax is the biggest number of the field
+	AggregateMax AggregateOperator = "max"
+)
+
+type (
+	// AggregateOperator defines what an Accumulator computes
+	AggregateOperator string
+
+	// Pipeline defines the aggregation done by *Collection.Aggregate.
+	// The documents are matched, grouped and the accumulators are computed for every group.
+	Pipeline struct {
+		// IndexName and Query select the documents with a bleve index.
+		// If Query is nil all the documents of the collection are read.
+		IndexName string
+		Query     query.Query
+		// Match keeps the documents for which it returns true. The document is given
+		// as the codec gives it to the indexes, a map for JSON documents.
+		Match func(id string, document interface{}) bool
+		// GroupBy is the path of the field the documents are grouped by, the fields are
+		// separated by dots. If it's empty all the documents are in one group.
+		// A document with a list of values is in the group of every value.
+		GroupBy string
+
+		Accumulators []Accumulator
+	}
+
+	// Accumulator computes a value for every group
+	Accumulator struct {
+		// Name is the key of the value in AggregateGroup.Values
+		Name     string
+		Operator AggregateOperator
+		// Field is the path of the field, the fields are separated by dots.
+		// The values which are not numbers are ignored.
+		Field string
+	}
+
+	// AggregateGroup is a group returned by *Collection.Aggregate
+	AggregateGroup struct {
+		// Key is the value of the GroupBy field, nil if the documents don't have it
+		Key interface{}
+		// Count is the number of documents in the group
+		Count int
+		// Values are the results of the accumulators. The averages, minimums and maximums
+		// are missing if no document of the group has a number for the field.
+		Values map[string]float64
+
+		// sortKey is the encoded key used to order the groups
+		sortKey      []byte
+		accumulators []*accumulatorState
+	}
+
+	accumulatorState struct {
+		count    int
+		sum      float64
+		min, max float64
+	}
+
+	// aggregator keeps the groups during the aggregation
+	aggregator struct {
+		pipeline *Pipeline
+		groupBy  OrderedIndexSelector
+		fields   []OrderedIndexSelector
+		groups   map[string]*AggregateGroup
+	}
+)
+
+// Aggregate reads the documents selected by the pipeline and returns the groups ordered by key.
+// The documents are read one by one with an iterator or page by page if a query is given,
+// only the groups are kept in memory.
+func (c *Collection) Aggregate(ctx context.Context, pipeline *Pipeline) ([]*AggregateGroup, error) {
+	agg, err := newAggregator(pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	codec, err := c.getCodec()
+	if err != nil {
+		return nil, err
+	}
+
+	add := func(id string, content []byte) error {
+		document, err := codec.ToIndex(content)
+		if err != nil {
+			return err
+		}
+
+		if pipeline.Match != nil && !pipeline.Match(id, document) {
+			return nil
+		}
+
+		agg.add(document)
+		return nil
+	}
+
+	if pipeline.Query != nil {
+		request := bleve.NewSearchRequestOptions(pipeline.Query, aggregatePageSize, 0, false)
+		var iter *SearchIterator
+		iter, err = c.SearchIterator(pipeline.IndexName, request)
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			var response *Response
+			response, err = iter.NextResponse(nil)
+			if err == ErrEndOfQueryResult {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+
+			err = add(response.ID, response.Content)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		iter := c.GetIterator()
+		defer iter.Close()
+
+		for ; iter.Valid(); iter.Next() {
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			var content []byte
+			content, err = iter.Bytes()
+			if err != nil {
+				return nil, err
+			}
+
+			err = add(iter.GetID(), content)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return agg.result(), nil
+}
+
+func newAggregator(pipeline *Pipeline) (*aggregator, error) {
+	agg := &aggregator{
+		pipeline: pipeline,
+		fields:   make([]OrderedIndexSelector, len(pipeline.Accumulators)),
+		groups:   map[string]*AggregateGroup{},
+	}
+
+	if pipeline.Query != nil && pipeline.IndexName == "" {
+		return nil, fmt.Errorf("the index name must be given with the query")
+	}
+
+	if pipeline.GroupBy != "" {
+		agg.groupBy = FieldSelector(strings.Split(pipeline.GroupBy, ".")...)
+	}
+
+	for i, accumulator := range pipeline.Accumulators {
+		switch accumulator.Operator {
+		case AggregateCount:
+		case AggregateSum, AggregateAvg, AggregateMin, AggregateMax:
+			if accumulator.Field == "" {
+				return nil, fmt.Errorf("the accumulator %q needs a field", accumulator.Name)
+			}
+		default:
+			return nil, fmt.Errorf("unknown operator %q for the accumulator %q", accumulator.Operator, accumulator.Name)
+		}
+
+		if accumulator.Field != "" {
+			agg.fields[i] = FieldSelector(strings.Split(accumulator.Field, ".")...)
+		}
+	}
+
+	return agg, nil
+}
+
+// add updates the groups of the document
+func (a *aggregator) add(document interface{}) {
+	keys := []interface{}{nil}
+	if a.groupBy != nil {
+		if values := a.groupBy(document); len(values) != 0 {
+			keys = values
+		}
+	}
+
+	// The values of the fields are read once even if the document is in many groups
+	fieldsValues := make([][]interface{}, len(a.fields))
+	for i, field := range a.fields {
+		if field != nil {
+			fieldsValues[i] = field(document)
+		}
+	}
+
+	done := map[string]bool{}
+	for _, key := range keys {
+		key, sortKey := aggregateGroupKey(key)
+		if done[string(sortKey)] {
+			continue
+		}
+		done[string(sortKey)] = true
+
+		group, ok := a.groups[string(sortKey)]
+		if !ok {
+			group = &AggregateGroup{
+				Key:          key,
+				sortKey:      sortKey,
+				accumulators: make([]*accumulatorState, len(a.fields)),
+			}
+			for i := range group.accumulators {
+				group.accumulators[i] = new(accumulatorState)
+			}
+			a.groups[string(sortKey)] = group
+		}
+
+		group.Count++
+		for i, state := range group.accumulators {
+			state.add(a.pipeline.Accumulators[i].Operator, a.fields[i] != nil, fieldsValues[i])
+		}
+	}
+}
+
+// result computes the values of the groups and returns them ordered by key
+func (a *aggregator) result() []*AggregateGroup {
+	ret := make([]*AggregateGroup, 0, len(a.groups))
+	for _, group := range a.groups {
+		group.Values = map[string]float64{}
+		for i, accumulator := range a.pipeline.Accumulators {
+			state := group.accumulators[i]
+			switch accumulator.Operator {
+			case AggregateCount:
+				group.Values[accumulator.Name] = float64(state.count)
+			case AggregateSum:
+				group.Values[accumulator.Name] = state.sum
+			case AggregateAvg:
+				if state.count != 0 {
+					group.Values[accumulator.Name] = state.sum / float64(state.count)
+				}
+			case AggregateMin:
+				if state.count != 0 {
+					group.Values[accumulator.Name] = state.min
+				}
+			case AggregateMax:
+				if state.count != 0 {
+					group.Values[accumulator.Name] = state.max
+				}
+			}
+		}
+		ret = append(ret, group)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return bytes.Compare(ret[i].sortKey, ret[j].sortKey) < 0
+	})
+
+	return ret
+}
+
+func (s *accumulatorState) add(operator AggregateOperator, hasField bool, values []interface{}) {
+	if operator == AggregateCount {
+		if !hasField {
+			s.count++
+			return
+		}
+		for _, value := range values {
+			if value != nil {
+				s.count++
+				return
+			}
+		}
+		return
+	}
+
+	for _, value := range values {
+		number, ok := numberValue(value)
+		if !ok {
+			continue
+		}
+
+		if s.count == 0 || number < s.min {
+			s.min = number
+		}
+		if s.count == 0 || number > s.max {
+			s.max = number
+		}
+		s.sum += number
+		s.count++
+	}
+}
+
+// aggregateGroupKey returns the key of the group and its encoded value used to order the groups.
+// The values which can't be ordered are grouped by their string representation.
+func aggregateGroupKey(value interface{}) (interface{}, []byte) {
+	if value == nil {
+		return nil, []byte{}
+	}
+
+	encoded, err := encodeOrderedValue(value)
+	if err != nil {
+		value = fmt.Sprint(value)
+		encoded, _ = encodeOrderedValue(value)
+	}
+
+	return value, encoded
+}
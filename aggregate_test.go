@@ -0,0 +1,144 @@
+package gotinydb
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+)
+
+func TestAggregate(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	var col *Collection
+	col, err = testDB.Use("orders")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = col.SetBleveIndex("all", bleve.NewIndexMapping())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	orders := []map[string]interface{}{
+		{"customer": "bob", "amount": 10, "tags": []string{"a", "b"}},
+		{"customer": "bob", "amount": 30, "tags": []string{"a"}},
+		{"customer": "alice", "amount": 5},
+		{"customer": "alice", "amount": "not a number"},
+		{"amount": 100},
+	}
+	for i, order := range orders {
+		err = col.Put(fmt.Sprintf("order %d", i), order)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	pipeline := &Pipeline{
+		GroupBy: "customer",
+		Accumulators: []Accumulator{
+			{Name: "count", Operator: AggregateCount},
+			{Name: "withAmount", Operator: AggregateCount, Field: "amount"},
+			{Name: "sum", Operator: AggregateSum, Field: "amount"},
+			{Name: "avg", Operator: AggregateAvg, Field: "amount"},
+			{Name: "min", Operator: AggregateMin, Field: "amount"},
+			{Name: "max", Operator: AggregateMax, Field: "amount"},
+		},
+	}
+
+	var groups []*AggregateGroup
+	groups, err = col.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	type expectedGroup struct {
+		key    interface{}
+		count  int
+		values map[string]float64
+	}
+	check := func(groups []*AggregateGroup, expected []expectedGroup) {
+		if len(groups) != len(expected) {
+			t.Errorf("expected %d groups but got %d", len(expected), len(groups))
+			return
+		}
+		for i, group := range groups {
+			if group.Key != expected[i].key || group.Count != expected[i].count || !reflect.DeepEqual(group.Values, expected[i].values) {
+				t.Errorf("expected %v %d %v but got %v %d %v", expected[i].key, expected[i].count, expected[i].values, group.Key, group.Count, group.Values)
+			}
+		}
+	}
+
+	check(groups, []expectedGroup{
+		{nil, 1, map[string]float64{"count": 1, "withAmount": 1, "sum": 100, "avg": 100, "min": 100, "max": 100}},
+		{"alice", 2, map[string]float64{"count": 2, "withAmount": 2, "sum": 5, "avg": 5, "min": 5, "max": 5}},
+		{"bob", 2, map[string]float64{"count": 2, "withAmount": 2, "sum": 40, "avg": 20, "min": 10, "max": 30}},
+	})
+
+	// The documents with many values are in many groups
+	groups, err = col.Aggregate(context.Background(), &Pipeline{
+		GroupBy: "tags",
+		Match: func(id string, document interface{}) bool {
+			return id != "order 4"
+		},
+		Accumulators: []Accumulator{
+			{Name: "sum", Operator: AggregateSum, Field: "amount"},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	check(groups, []expectedGroup{
+		{nil, 2, map[string]float64{"sum": 5}},
+		{"a", 2, map[string]float64{"sum": 40}},
+		{"b", 1, map[string]float64{"sum": 10}},
+	})
+
+	// The documents are selected by the index
+	query := bleve.NewMatchQuery("bob")
+	query.SetField("customer")
+	groups, err = col.Aggregate(context.Background(), &Pipeline{
+		IndexName: "all",
+		Query:     query,
+		Accumulators: []Accumulator{
+			{Name: "max", Operator: AggregateMax, Field: "amount"},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	check(groups, []expectedGroup{
+		{nil, 2, map[string]float64{"max": 30}},
+	})
+
+	_, err = col.Aggregate(context.Background(), &Pipeline{
+		Accumulators: []Accumulator{
+			{Name: "sum", Operator: AggregateSum},
+		},
+	})
+	if err == nil {
+		t.Errorf("the sum needs a field")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = col.Aggregate(ctx, &Pipeline{})
+	if err != context.Canceled {
+		t.Errorf("expected %v but got %v", context.Canceled, err)
+		return
+	}
+}
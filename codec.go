@@ -0,0 +1,289 @@
+package gotinydb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ugorji/go/codec"
+)
+
+type (
+	// Codec defines how the documents are converted into bytes before being saved
+	// and how the bytes are converted back. A codec is set per collection with
+	// *Collection.SetCodec and the default is JSON.
+	// Documents given as []byte are saved as is without going through the codec.
+	Codec interface {
+		// Name must be unique. It is saved into the collection configuration to
+		// find back the codec when the database is opened.
+		Name() string
+		Marshal(v interface{}) ([]byte, error)
+		Unmarshal(data []byte, v interface{}) error
+		// ToIndex decodes the saved bytes into a value Bleve can index.
+		// It is used when existing documents are indexed.
+		ToIndex(data []byte) (interface{}, error)
+	}
+
+	jsonCodec struct{}
+
+	ugorjiCodec struct {
+		name   string
+		handle codec.Handle
+	}
+
+	gobCodec struct {
+		name     string
+		newValue func() interface{}
+	}
+
+	protobufCodec struct {
+		name       string
+		newMessage func() proto.Message
+	}
+)
+
+var (
+	// JSONCodec saves documents with encoding/json.
+	// Numbers are decoded as json.Number when the destination is an interface.
+	JSONCodec Codec = &jsonCodec{}
+	// MsgpackCodec saves documents as MessagePack.
+	MsgpackCodec Codec = &ugorjiCodec{
+		name: "msgpack",
+		handle: &codec.MsgpackHandle{
+			RawToString: true,
+			WriteExt:    true,
+			BasicHandle: codec.BasicHandle{
+				DecodeOptions: codec.DecodeOptions{
+					MapType: reflect.TypeOf(map[string]interface{}(nil)),
+				},
+			},
+		},
+	}
+	// CBORCodec saves documents as CBOR (RFC 7049).
+	CBORCodec Codec = &ugorjiCodec{
+		name: "cbor",
+		handle: &codec.CborHandle{
+			BasicHandle: codec.BasicHandle{
+				DecodeOptions: codec.DecodeOptions{
+					MapType: reflect.TypeOf(map[string]interface{}(nil)),
+				},
+			},
+		},
+	}
+
+	codecs = map[string]Codec{
+		JSONCodec.Name():    JSONCodec,
+		MsgpackCodec.Name(): MsgpackCodec,
+		CBORCodec.Name():    CBORCodec,
+	}
+	codecsLock sync.RWMutex
+)
+
+// RegisterCodec makes a codec available by its name.
+// The custom codecs must be registered before the database is opened.
+// It returns ErrNameAllreadyExists if another codec is registered with the same name,
+// registering the same codec again does nothing.
+// The JSON, MessagePack and CBOR codecs are already registered.
+func RegisterCodec(c Codec) error {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+
+	if registered, found := codecs[c.Name()]; found {
+		if registered != c {
+			return ErrNameAllreadyExists
+		}
+		return nil
+	}
+
+	codecs[c.Name()] = c
+	return nil
+}
+
+func getCodec(name string) (Codec, error) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+
+	c, ok := codecs[name]
+	if !ok {
+		return nil, ErrCodecNotFound
+	}
+	return c, nil
+}
+
+// NewGobCodec returns a codec using encoding/gob.
+// newValue must return a pointer to a new value of the documents type,
+// it is used to decode the documents for indexing.
+func NewGobCodec(name string, newValue func() interface{}) Codec {
+	return &gobCodec{
+		name:     name,
+		newValue: newValue,
+	}
+}
+
+// NewProtobufCodec returns a codec using protocol buffers.
+// All documents must implement proto.Message and newMessage must return a new message
+// of the documents type, it is used to decode the documents for indexing.
+func NewProtobufCodec(name string, newMessage func() proto.Message) Codec {
+	return &protobufCodec{
+		name:       name,
+		newMessage: newMessage,
+	}
+}
+
+func (c *jsonCodec) Name() string {
+	return "json"
+}
+
+func (c *jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (c *jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewBuffer(data))
+	decoder.UseNumber()
+
+	return decoder.Decode(v)
+}
+
+func (c *jsonCodec) ToIndex(data []byte) (interface{}, error) {
+	elem := map[string]interface{}{}
+	err := json.Unmarshal(data, &elem)
+	if err != nil {
+		return nil, err
+	}
+	return elem, nil
+}
+
+func (c *ugorjiCodec) Name() string {
+	return c.name
+}
+
+func (c *ugorjiCodec) Marshal(v interface{}) (ret []byte, err error) {
+	err = codec.NewEncoderBytes(&ret, c.handle).Encode(v)
+	return
+}
+
+func (c *ugorjiCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, c.handle).Decode(v)
+}
+
+func (c *ugorjiCodec) ToIndex(data []byte) (interface{}, error) {
+	elem := map[string]interface{}{}
+	err := c.Unmarshal(data, &elem)
+	if err != nil {
+		return nil, err
+	}
+	return elem, nil
+}
+
+func (c *gobCodec) Name() string {
+	return c.name
+}
+
+func (c *gobCodec) Marshal(v interface{}) ([]byte, error) {
+	buff := bytes.NewBuffer(nil)
+	err := gob.NewEncoder(buff).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func (c *gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (c *gobCodec) ToIndex(data []byte) (interface{}, error) {
+	elem := c.newValue()
+	err := c.Unmarshal(data, elem)
+	if err != nil {
+		return nil, err
+	}
+	return elem, nil
+}
+
+func (c *protobufCodec) Name() string {
+	return c.name
+}
+
+func (c *protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("the protobuf codec needs a proto.Message but got %T", v)
+	}
+	return proto.Marshal(message)
+}
+
+func (c *protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("the protobuf codec needs a proto.Message but got %T", v)
+	}
+	return proto.Unmarshal(data, message)
+}
+
+func (c *protobufCodec) ToIndex(data []byte) (interface{}, error) {
+	elem := c.newMessage()
+	err := proto.Unmarshal(data, elem)
+	if err != nil {
+		return nil, err
+	}
+	return elem, nil
+}
+
+// SetCodec defines the codec used to save the documents of the collection.
+// It can only be changed when the collection is empty and the codec is registered
+// if it was not.
+func (c *Collection) SetCodec(codec Codec) error {
+	iter := c.GetIterator()
+	notEmpty := iter.Valid()
+	iter.Close()
+	if notEmpty {
+		return ErrCollectionNotEmpty
+	}
+
+	err := RegisterCodec(codec)
+	if err != nil {
+		return err
+	}
+
+	c.codecLock.Lock()
+	c.CodecName = codec.Name()
+	c.codec = codec
+	c.codecLock.Unlock()
+
+	return c.db.saveConfig()
+}
+
+// getCodec returns the codec of the collection. JSON is used if none is defined.
+// The custom codecs can be registered after the opening, the codec is found and kept
+// the first time it's needed.
+func (c *Collection) getCodec() (Codec, error) {
+	c.codecLock.RLock()
+	codec := c.codec
+	c.codecLock.RUnlock()
+	if codec != nil {
+		return codec, nil
+	}
+
+	c.codecLock.Lock()
+	defer c.codecLock.Unlock()
+
+	if c.codec != nil {
+		return c.codec, nil
+	}
+	if c.CodecName == "" {
+		return JSONCodec, nil
+	}
+
+	codec, err := getCodec(c.CodecName)
+	if err != nil {
+		return nil, err
+	}
+	c.codec = codec
+	return codec, nil
+}
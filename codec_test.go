@@ -0,0 +1,189 @@
+package gotinydb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	"github.com/golang/protobuf/proto"
+)
+
+type testProtoUser struct {
+	Name  string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Email string `protobuf:"bytes,2,opt,name=email,proto3" json:"email,omitempty"`
+}
+
+func (m *testProtoUser) Reset()         { *m = testProtoUser{} }
+func (m *testProtoUser) String() string { return proto.CompactTextString(m) }
+func (*testProtoUser) ProtoMessage()    {}
+
+// The codecs are registered once by name, they are shared by the tests
+var (
+	testGobCodec   = NewGobCodec("gob test user", func() interface{} { return new(testUserStruct) })
+	testProtoCodec = NewProtobufCodec("protobuf test user", func() proto.Message { return new(testProtoUser) })
+)
+
+func TestCodecs(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	err = testCol.SetCodec(MsgpackCodec)
+	if err != ErrCollectionNotEmpty {
+		t.Errorf("the codec can't be changed on a non empty collection but got %v", err)
+		return
+	}
+
+	codecsToTest := []Codec{
+		JSONCodec,
+		MsgpackCodec,
+		CBORCodec,
+		testGobCodec,
+	}
+
+	for _, codec := range codecsToTest {
+		var col *Collection
+		col, err = testDB.Use("codec " + codec.Name())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		err = col.SetCodec(codec)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		err = col.Put(testUserID, testUser)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		retrievedUser := new(testUserStruct)
+		_, err = col.Get(testUserID, retrievedUser)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !reflect.DeepEqual(retrievedUser, testUser) {
+			t.Errorf("codec %q: expected %v but got %v", codec.Name(), testUser, retrievedUser)
+			return
+		}
+
+		// Existing documents are decoded with the codec to be indexed
+		err = col.SetBleveIndex("all", bleve.NewIndexMapping())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		_, err = col.Search("all", bleve.NewQueryStringQuery(testUser.Name))
+		if err != nil {
+			t.Errorf("codec %q: the existing document must be indexed: %s", codec.Name(), err.Error())
+			return
+		}
+	}
+
+	// The codec must be found back after a restart
+	testDB.Close()
+	testDB, err = Open(testPath, testConfigKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var col *Collection
+	col, err = testDB.Use("codec " + CBORCodec.Name())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	retrievedUser := new(testUserStruct)
+	_, err = col.Get(testUserID, retrievedUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(retrievedUser, testUser) {
+		t.Errorf("expected %v but got %v", testUser, retrievedUser)
+		return
+	}
+}
+
+func TestProtobufCodec(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	col, err := testDB.Use("codec protobuf")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = col.SetCodec(testProtoCodec)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	user := &testProtoUser{Name: testUser.Name, Email: testUser.Email}
+	err = col.Put(testUserID, user)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The documents must be protobuf messages
+	err = col.Put("not a message", testUser)
+	if err == nil {
+		t.Errorf("the document must be refused")
+		return
+	}
+
+	retrievedUser := new(testProtoUser)
+	_, err = col.Get(testUserID, retrievedUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !proto.Equal(retrievedUser, user) {
+		t.Errorf("expected %v but got %v", user, retrievedUser)
+		return
+	}
+
+	// Existing documents are decoded with the codec to be indexed
+	err = col.SetBleveIndex("all", bleve.NewIndexMapping())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = col.Search("all", bleve.NewQueryStringQuery(testUser.Name))
+	if err != nil {
+		t.Errorf("the existing document must be indexed: %s", err.Error())
+		return
+	}
+
+	// The name can't be taken by another codec
+	err = RegisterCodec(testProtoCodec)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = RegisterCodec(NewProtobufCodec("protobuf test user", func() proto.Message { return new(testProtoUser) }))
+	if err != ErrNameAllreadyExists {
+		t.Errorf("expected %v but got %v", ErrNameAllreadyExists, err)
+		return
+	}
+	err = RegisterCodec(NewGobCodec(JSONCodec.Name(), func() interface{} { return new(testUserStruct) }))
+	if err != ErrNameAllreadyExists {
+		t.Errorf("expected %v but got %v", ErrNameAllreadyExists, err)
+		return
+	}
+}
@@ -1,21 +1,20 @@
 package gotinydb
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"reflect"
 	"sync"
 
 	"github.com/alexandrestein/gotinydb/blevestore"
-	"github.com/alexandrestein/gotinydb/cipher"
+	"github.com/alexandrestein/gotinydb/compress"
 	"github.com/alexandrestein/gotinydb/transaction"
 	"github.com/blevesearch/bleve"
 	"github.com/blevesearch/bleve/index/upsidedown"
 	"github.com/blevesearch/bleve/mapping"
 	"github.com/blevesearch/bleve/search/query"
 	"github.com/dgraph-io/badger"
+	"github.com/xeipuuv/gojsonschema"
 	"golang.org/x/crypto/blake2b"
 )
 
@@ -27,6 +26,57 @@ type (
 		db *DB
 		// BleveIndexes in public for marshalling reason and should never be used directly
 		BleveIndexes []*BleveIndex
+		// Schema is public for marshalling reason and should never be used directly.
+		// Use *Collection.SetSchema to change it.
+		Schema []byte
+		// CodecName is public for marshalling reason and should never be used directly.
+		// Use *Collection.SetCodec to change it.
+		CodecName string
+		// Compression and CompressionLevel are public for marshalling reason and should
+		// never be used directly. Use *Collection.SetCompression to change them.
+		Compression      byte
+		CompressionLevel int
+		// OrderedIndexes is public for marshalling reason and should never be used directly.
+		// Use *Collection.SetOrderedIndex to change it.
+		OrderedIndexes []*OrderedIndex
+		// Views is public for marshalling reason and should never be used directly.
+		// Use *Collection.DefineView to change it.
+		Views []*View
+		// RefIntegrity is public for marshalling reason and should never be used directly.
+		// Use *Collection.SetRefIntegrity to change it.
+		RefIntegrity bool
+
+		schema *gojsonschema.Schema
+		hooks  hooks
+
+		// codec is found from CodecName the first time it's needed
+		codec     Codec
+		codecLock sync.RWMutex
+
+		// indexing manages the asynchronous indexes
+		indexing asyncIndexing
+
+		// buildingIndexes are the indexes built in the background by *Collection.UpdateBleveIndexMapping
+		buildingIndexes     []*buildingIndex
+		buildingIndexesLock sync.Mutex
+		// indexBuildLocks serialize the builds of the bleve indexes by name
+		indexBuildLocks map[string]*sync.Mutex
+		// bleveIndexesLock protects the list of the bleve indexes which is replaced
+		// and never modified in place
+		bleveIndexesLock sync.RWMutex
+		// orderedIndexesLock and viewsLock protect the lists of the ordered indexes and of the views.
+		// Like the bleve indexes the lists are replaced and never modified in place.
+		orderedIndexesLock sync.RWMutex
+		viewsLock          sync.RWMutex
+	}
+
+	// indexedDocument is the content of a written document decoded for the ordered indexes
+	// and the views. It's decoded once, the first time an index or a view needs it.
+	indexedDocument struct {
+		c        *Collection
+		content  []byte
+		document interface{}
+		decoded  bool
 	}
 
 	// Batch is a simple struct to manage multiple write in one commit
@@ -41,6 +91,7 @@ type (
 		i                         int
 		pointer                   interface{}
 		asBytes, encryptedAsBytes []byte
+		userMeta                  byte
 		err                       error
 	}
 )
@@ -65,118 +116,210 @@ func (c *Collection) buildIndexPrefix() []byte {
 // SetBleveIndex adds a bleve index to the collection.
 // It build a new index with the given index mapping.
 func (c *Collection) SetBleveIndex(name string, bleveMapping mapping.IndexMapping) (err error) {
-	// Use only the tow first bytes as index prefix.
-	// The prefix is used to confine indexes with a prefixes.
-	prefix := c.buildIndexPrefix()
-	indexHash := blake2b.Sum256([]byte(name))
-	prefix = append(prefix, indexHash[:2]...)
+	return c.setBleveIndex(context.Background(), name, bleveMapping, nil)
+}
 
-	// Check there is no conflict name or hash
+func (c *Collection) setBleveIndex(ctx context.Context, name string, bleveMapping mapping.IndexMapping, progress func(done, total int)) (err error) {
+	// Check there is no conflict name
 	for _, i := range c.BleveIndexes {
 		if i.Name == name {
 			return ErrNameAllreadyExists
 		}
+	}
+
+	// Use only the tow first bytes as index prefix.
+	// The prefix is used to confine indexes with a prefixes.
+	indexHash := blake2b.Sum256([]byte(name))
+
+	// ok, start building a new index
+	index, err := c.buildBleveIndex(name, indexHash[:2], bleveMapping)
+	if err != nil {
+		return err
+	}
+
+	// Add the new index to the list of index of this collection
+	// The list is copied because it can be read without the lock
+	c.bleveIndexesLock.Lock()
+	c.BleveIndexes = append(c.BleveIndexes[:len(c.BleveIndexes):len(c.BleveIndexes)], index)
+	c.bleveIndexesLock.Unlock()
+
+	// Index all existing values
+	err = c.indexExistingDocuments(ctx, index, progress)
+	if err != nil {
+		return err
+	}
+
+	// Save the new settup
+	return c.db.saveConfig()
+}
+
+// buildBleveIndex initializes a new bleve index saved under the given hash
+func (c *Collection) buildBleveIndex(name string, indexHash []byte, bleveMapping mapping.IndexMapping) (index *BleveIndex, err error) {
+	prefix := c.buildIndexPrefix()
+	prefix = append(prefix, indexHash...)
+
+	// Check there is no conflict hash
+	for _, i := range c.BleveIndexes {
 		if reflect.DeepEqual(i.Prefix, prefix) {
-			return ErrHashCollision
+			return nil, ErrHashCollision
 		}
 	}
 
-	// ok, start building a new index
-	index := newIndex(name)
-	index.Name = name
+	index = newIndex(name)
 	index.Prefix = prefix
 
 	// Bleve needs to save some parts on the drive.
 	// The path is based on a part of the collection hash and the index prefix.
 	colHash := blake2b.Sum256([]byte(c.Name))
-	index.Path = fmt.Sprintf("%s/%x/%x", c.db.path, colHash[:2], indexHash[:2])
+	index.Path = fmt.Sprintf("%s/%x/%x", c.db.path, colHash[:2], indexHash)
 
 	// Build the configuration to use the local bleve storage and initialize the index
 	config := blevestore.NewConfigMap(c.db.ctx, index.Path, c.db.PrivateKey, prefix, c.db.badger, c.db.writeChan)
 	index.bleveIndex, err = bleve.NewUsing(index.Path, bleveMapping, upsidedown.Name, blevestore.Name, config)
 	if err != nil {
-		return
+		return nil, err
 	}
 
 	// Save the on drive bleve element into the index struct itself
 	index.BleveIndexAsBytes, err = index.indexZipper()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Add the new index to the list of index of this collection
-	c.BleveIndexes = append(c.BleveIndexes, index)
+	return index, nil
+}
 
-	// Index all existing values
-	err = c.db.badger.View(func(txn *badger.Txn) error {
-		iter := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer iter.Close()
+func (c *Collection) putSendToWriteAndWaitForResponse(tr *transaction.Transaction) (err error) {
+	select {
+	case c.db.writeChan <- tr:
+	case <-c.db.ctx.Done():
+		return c.db.ctx.Err()
+	}
 
-		colPrefix := c.buildDBKey("")
-		for iter.Seek(colPrefix); iter.ValidForPrefix(colPrefix); iter.Next() {
-			item := iter.Item()
+	select {
+	case err = <-tr.ResponseChan:
+	case <-tr.Ctx.Done():
+		err = tr.Ctx.Err()
+	}
 
-			var err error
-			var itemAsEncryptedBytes []byte
-			itemAsEncryptedBytes, err = item.ValueCopy(itemAsEncryptedBytes)
-			if err != nil {
-				continue
-			}
+	return err
+}
+
+// writeOperationsByBatches writes the operations by batches to not have too big transactions
+func (c *Collection) writeOperationsByBatches(ops []*transaction.Operation) error {
+	ctx, cancel := context.WithCancel(c.db.ctx)
+	defer cancel()
 
-			var clearBytes []byte
-			clearBytes, err = cipher.Decrypt(c.db.PrivateKey, item.Key(), itemAsEncryptedBytes)
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > 1000 {
+			n = 1000
+		}
 
-			id := string(item.Key()[len(colPrefix):])
+		tr := transaction.New(ctx)
+		tr.Operations = ops[:n]
+		ops = ops[n:]
 
-			content := c.fromValueBytesGetContentToIndex(clearBytes)
-			err = index.bleveIndex.Index(id, content)
-			if err != nil {
-				return err
+		err := c.putSendToWriteAndWaitForResponse(tr)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeOperationsLocked writes the operations by batches without the writer.
+// The caller must hold the commit lock, the writer is waiting for it.
+func (d *DB) writeOperationsLocked(ops []*transaction.Operation) error {
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > 1000 {
+			n = 1000
+		}
+
+		err := d.badger.Update(func(txn *badger.Txn) error {
+			for _, op := range ops[:n] {
+				err := d.writeOperation(txn, op)
+				if err != nil {
+					return err
+				}
 			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
+		ops = ops[n:]
+	}
 
-		return nil
-	})
+	return nil
+}
+
+// backfill adds an element updated by the hooks, like an ordered index or a view, and writes
+// the operations returned by fn for the existing documents.
+// The commits of the other writes wait until it's done, this way the documents can't change
+// between the reading and the writing and the later writes update the element with their hooks.
+// If an error occurs the element is removed with remove before the other writes continue.
+func (c *Collection) backfill(add, remove func(), fn func(id string, document interface{}) ([]*transaction.Operation, error)) error {
+	codec, err := c.getCodec()
 	if err != nil {
 		return err
 	}
 
-	// Save the new settup
-	return c.db.saveConfig()
-}
+	c.db.commitLock.Lock()
+	defer c.db.commitLock.Unlock()
 
-func (c *Collection) putSendToWriteAndWaitForResponse(tr *transaction.Transaction) (err error) {
-	select {
-	case c.db.writeChan <- tr:
-	case <-c.db.ctx.Done():
-		return c.db.ctx.Err()
-	}
+	add()
 
-	select {
-	case err = <-tr.ResponseChan:
-	case <-tr.Ctx.Done():
-		err = tr.Ctx.Err()
+	ops, err := c.existingDocumentsOperations(codec, fn)
+	if err == nil {
+		err = c.db.writeOperationsLocked(ops)
+	}
+	if err != nil {
+		remove()
+		return err
 	}
 
-	return err
+	return nil
 }
 
-func (c *Collection) putLoopForIndexes(tr *transaction.Transaction) (err error) {
-	for _, index := range c.BleveIndexes {
-		for _, op := range tr.Operations {
-			// If remove the content no need to index it
-			if op.Delete {
+// existingDocumentsOperations returns the operations returned by fn for the existing documents
+func (c *Collection) existingDocumentsOperations(codec Codec, fn func(id string, document interface{}) ([]*transaction.Operation, error)) ([]*transaction.Operation, error) {
+	ops := []*transaction.Operation{}
+	err := c.db.badger.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		colPrefix := c.buildDBKey("")
+		for iter.Seek(colPrefix); iter.ValidForPrefix(colPrefix); iter.Next() {
+			item := iter.Item()
+
+			clearBytes, err := c.db.decryptAndDecompressItem(item)
+			if err != nil {
 				continue
 			}
 
-			err = index.bleveIndex.Index(op.CollectionID, op.Content)
+			// The documents which can't be decoded are skipped like by the hooks
+			document, err := codec.ToIndex(clearBytes)
+			if err != nil {
+				continue
+			}
+
+			docOps, err := fn(string(item.Key()[len(colPrefix):]), document)
 			if err != nil {
 				return err
 			}
+			ops = append(ops, docOps...)
 		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return ops, nil
 }
 
 func (c *Collection) put(id string, content interface{}, clean bool) error {
@@ -227,39 +370,123 @@ func (c *Collection) buildOperation(id string, content interface{}, delete, clea
 	var bytes []byte
 	if tmpBytes, ok := content.([]byte); ok {
 		bytes = tmpBytes
-	} else {
-		jsonBytes, marshalErr := json.Marshal(content)
+	} else if !delete {
+		codec, err := c.getCodec()
+		if err != nil {
+			return nil, err
+		}
+
+		encodedBytes, marshalErr := codec.Marshal(content)
 		if marshalErr != nil {
 			return nil, marshalErr
 		}
-		bytes = jsonBytes
+		bytes = encodedBytes
+	}
+
+	if !delete {
+		err := c.validate(id, bytes)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return transaction.NewOperation(id, content, c.buildDBKey(id), bytes, delete, cleanHistory), nil
+	op := transaction.NewOperation(id, content, c.buildDBKey(id), bytes, delete, cleanHistory)
+	op.Compressible = true
+	op.Compression = c.Compression
+	op.CompressionLevel = c.CompressionLevel
+
+	// The ordered indexes, the views and the references are updated by the writer in the same commit
+	document := &indexedDocument{c: c}
+	if !delete {
+		document.content = bytes
+	}
+
+	var err error
+	op.Hook, op.Check, err = c.buildOrderedIndexesHook(id, document, delete)
+	if err != nil {
+		return nil, err
+	}
+
+	var viewsHook func(txn *badger.Txn) error
+	viewsHook, err = c.buildViewsHook(id, document, delete)
+	if err != nil {
+		return nil, err
+	}
+
+	var refsHook, refsCheck func(txn *badger.Txn) error
+	refsHook, refsCheck, err = c.buildRefsHook(id, document, delete)
+	if err != nil {
+		return nil, err
+	}
+
+	op.Hook = chainHooks(op.Hook, viewsHook, refsHook)
+	op.Check = chainHooks(op.Check, refsCheck)
+
+	return op, nil
+}
+
+// get returns the decoded document or nil if the document is deleted or can't be decoded
+func (d *indexedDocument) get() (interface{}, error) {
+	if d.decoded || d.content == nil {
+		return d.document, nil
+	}
+
+	codec, err := d.c.getCodec()
+	if err != nil {
+		return nil, err
+	}
+	// Like for bleve the documents which can't be decoded are not indexed
+	d.document, err = codec.ToIndex(d.content)
+	if err != nil {
+		d.document = nil
+	}
+	d.decoded = true
+
+	return d.document, nil
+}
+
+// SetCompression defines the compression algorithm applied to the documents before
+// encryption. The algorithms are defined in the compress package and the level is
+// only used by compress.Zstd, zero means the default level.
+// Existing documents stay readable and are compressed when they are updated.
+func (c *Collection) SetCompression(algorithm byte, level int) error {
+	err := compress.CheckAlgorithm(algorithm)
+	if err != nil {
+		return err
+	}
+
+	c.Compression = algorithm
+	c.CompressionLevel = level
+
+	return c.db.saveConfig()
 }
 
 // writeBatch gives a simple access to batch operations
 func (c *Collection) writeBatch(b *Batch) (err error) {
-	err = c.putSendToWriteAndWaitForResponse(b.tr)
+	// The pending index work is saved in the same commit as the documents
+	journal := c.buildIndexJournalOperations(b.tr.Operations)
+	toWrite := transaction.New(b.tr.Ctx)
+	toWrite.Operations = append(toWrite.Operations, b.tr.Operations...)
+	toWrite.Operations = append(toWrite.Operations, journal...)
+
+	err = c.putSendToWriteAndWaitForResponse(toWrite)
 	if err != nil {
 		return err
 	}
 
-	return c.putLoopForIndexes(b.tr)
+	return c.indexAfterWrite(b.tr, journal)
 }
 
 func (c *Collection) fromValueBytesGetContentToIndex(input []byte) interface{} {
-	var elem interface{}
-	decoder := json.NewDecoder(bytes.NewBuffer(input))
-
-	if jsonErr := decoder.Decode(&elem); jsonErr != nil {
-		fmt.Println("errjsonErr", jsonErr)
+	codec, err := c.getCodec()
+	if err != nil {
 		return nil
 	}
 
-	var ret interface{}
-	typed := elem.(map[string]interface{})
-	ret = typed
+	ret, err := codec.ToIndex(input)
+	if err != nil {
+		return nil
+	}
 
 	return ret
 }
@@ -280,6 +507,7 @@ func (c *Collection) getEncrypted(txn *badger.Txn, caller *multiGetCaller) (err
 	if err != nil {
 		return err
 	}
+	caller.userMeta = item.UserMeta()
 
 	return
 }
@@ -326,16 +554,23 @@ func (c *Collection) decryptAndUnmarshal(caller *multiGetCaller) (err error) {
 		return err
 	}
 
+	contentAsBytes, err = decompressDocument(caller.userMeta, contentAsBytes)
+	if err != nil {
+		return err
+	}
+
 	caller.asBytes = contentAsBytes
 
 	if caller.pointer == nil {
 		return nil
 	}
 
-	decoder := json.NewDecoder(bytes.NewBuffer(contentAsBytes))
-	decoder.UseNumber()
+	codec, err := c.getCodec()
+	if err != nil {
+		return err
+	}
 
-	uMarshalErr := decoder.Decode(caller.pointer)
+	uMarshalErr := codec.Unmarshal(contentAsBytes, caller.pointer)
 	if uMarshalErr != nil {
 		return uMarshalErr
 	}
@@ -362,57 +597,46 @@ func (c *Collection) GetMulti(ids []string, destinations []interface{}) (content
 
 	contentsAsBytes = make([][]byte, len(ids))
 
-	var wg sync.WaitGroup
-	wg.Add(len(ids))
-
 	respChan := make(chan *multiGetCaller, len(ids))
+	started := 0
 
-	c.db.badger.View(func(txn *badger.Txn) error {
+	err = c.db.badger.View(func(txn *badger.Txn) error {
 		for i, id := range ids {
-			var caller *multiGetCaller
-			caller, err = c.buildGetCaller(txn, id, destinations[i])
+			caller, err := c.buildGetCaller(txn, id, destinations[i])
 			if err != nil {
-				caller.err = err
 				return err
 			}
+			caller.i = i
 
 			err = c.getEncrypted(txn, caller)
 			if err != nil {
-				caller.err = err
 				return err
 			}
 
+			started++
 			go func() {
-				c.decryptAndUnmarshal(caller)
-				if caller.err != nil {
-					caller.err = err
-				}
+				caller.err = c.decryptAndUnmarshal(caller)
 				respChan <- caller
 			}()
 		}
 		return nil
 	})
 
-	go func() {
-		for {
-			caller := <-respChan
-			if caller.err != nil {
-				err = caller.err
-				return
-			}
-
-			contentsAsBytes[caller.i] = caller.asBytes
-
-			wg.Done()
+	// Wait for all started decryptions even if there is an error
+	for i := 0; i < started; i++ {
+		caller := <-respChan
+		if caller.err != nil {
+			err = caller.err
+			continue
 		}
-	}()
+
+		contentsAsBytes[caller.i] = caller.asBytes
+	}
 
 	if err != nil {
 		return nil, err
 	}
 
-	wg.Wait()
-
 	return
 }
 
@@ -421,38 +645,23 @@ func (c *Collection) Delete(id string) (err error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	tr := transaction.New(ctx)
-	tr.AddOperation(
-		transaction.NewOperation(id, nil, c.buildDBKey(id), nil, true, false),
-	)
-
-	// Send to the write channel
-	select {
-	case c.db.writeChan <- tr:
-	case <-c.db.ctx.Done():
-		return c.db.ctx.Err()
-	}
-
-	// Wait for response from the write routine
-	select {
-	case err = <-tr.ResponseChan:
-	case <-tr.Ctx.Done():
-		err = tr.Ctx.Err()
+	b, err := c.NewBatch(ctx)
+	if err != nil {
+		return err
 	}
 
-	// Deletes from index
-	for _, index := range c.BleveIndexes {
-		err = index.bleveIndex.Delete(id)
-		if err != nil {
-			return err
-		}
+	err = b.Delete(id)
+	if err != nil {
+		return err
 	}
 
-	return
+	return c.writeBatch(b)
 }
 
 func (c *Collection) buildDBKey(id string) []byte {
-	key := append(c.Prefix, prefixCollectionsData)
+	key := make([]byte, len(c.Prefix), len(c.Prefix)+1+len(id))
+	copy(key, c.Prefix)
+	key = append(key, prefixCollectionsData)
 	return append(key, []byte(id)...)
 }
 
@@ -464,7 +673,7 @@ func (c *Collection) buildJustTooBigDBPrefix() []byte {
 
 // GetBleveIndex gives an  easy way to interact directly with bleve
 func (c *Collection) GetBleveIndex(name string) (*BleveIndex, error) {
-	for _, bi := range c.BleveIndexes {
+	for _, bi := range c.getBleveIndexes() {
 		if bi.Name == name {
 			return bi, nil
 		}
@@ -503,6 +712,18 @@ func (c *Collection) SearchWithOptions(indexName string, searchRequest *bleve.Se
 	return ret, nil
 }
 
+// SearchWithRequest does the same as *Collection.SearchWithOptions with the options of the SearchRequest
+func (c *Collection) SearchWithRequest(ctx context.Context, indexName string, searchRequest *SearchRequest) (*SearchResult, error) {
+	if searchRequest.WaitIndexed {
+		err := c.WaitIndexed(ctx, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.SearchWithOptions(indexName, searchRequest.SearchRequest)
+}
+
 // History returns the previous versions of the given id.
 // The first value is the actual value and more you travel inside the list more the
 // records are old.
@@ -529,12 +750,7 @@ func (c *Collection) History(id string, limit int) (valuesAsBytes [][]byte, err
 			}
 
 			var content []byte
-			content, err = item.ValueCopy(content)
-			if err != nil {
-				return err
-			}
-
-			content, err = c.db.decryptData(item.Key(), content)
+			content, err = c.db.decryptAndDecompressItem(item)
 			if err != nil {
 				return err
 			}
@@ -554,17 +770,20 @@ func (c *Collection) History(id string, limit int) (valuesAsBytes [][]byte, err
 // DeleteIndex delete the index and all references
 func (c *Collection) DeleteIndex(name string) {
 	var index *BleveIndex
+	c.bleveIndexesLock.Lock()
 	for i, tmpIndex := range c.BleveIndexes {
 		if tmpIndex.Name == name {
 			index = tmpIndex
 
-			copy(c.BleveIndexes[i:], c.BleveIndexes[i+1:])
-			c.BleveIndexes[len(c.BleveIndexes)-1] = nil // or the zero value of T
-			c.BleveIndexes = c.BleveIndexes[:len(c.BleveIndexes)-1]
+			// The list is copied because it can be read without the lock
+			indexes := make([]*BleveIndex, 0, len(c.BleveIndexes)-1)
+			indexes = append(indexes, c.BleveIndexes[:i]...)
+			c.BleveIndexes = append(indexes, c.BleveIndexes[i+1:]...)
 
 			break
 		}
 	}
+	c.bleveIndexesLock.Unlock()
 
 	index.close()
 	index.delete()
@@ -572,11 +791,59 @@ func (c *Collection) DeleteIndex(name string) {
 	c.db.deletePrefix(index.Prefix)
 }
 
+// getBleveIndexes returns the list of the bleve indexes.
+// The list must not be modified, it's replaced when an index changes.
+func (c *Collection) getBleveIndexes() []*BleveIndex {
+	c.bleveIndexesLock.RLock()
+	defer c.bleveIndexesLock.RUnlock()
+
+	return c.BleveIndexes
+}
+
+// getOrderedIndexes returns the list of the ordered indexes.
+// The list must not be modified, it's replaced when an index is added or removed.
+func (c *Collection) getOrderedIndexes() []*OrderedIndex {
+	c.orderedIndexesLock.RLock()
+	defer c.orderedIndexesLock.RUnlock()
+
+	return c.OrderedIndexes
+}
+
+// getViews returns the list of the views.
+// The list must not be modified, it's replaced when a view is added or removed.
+func (c *Collection) getViews() []*View {
+	c.viewsLock.RLock()
+	defer c.viewsLock.RUnlock()
+
+	return c.Views
+}
+
+// replaceBleveIndex replaces the old index by the new one in the list of the bleve indexes
+func (c *Collection) replaceBleveIndex(oldIndex, newIndex *BleveIndex) {
+	c.bleveIndexesLock.Lock()
+	defer c.bleveIndexesLock.Unlock()
+
+	indexes := make([]*BleveIndex, len(c.BleveIndexes))
+	copy(indexes, c.BleveIndexes)
+	for i, tmpIndex := range indexes {
+		if tmpIndex == oldIndex {
+			indexes[i] = newIndex
+			break
+		}
+	}
+	c.BleveIndexes = indexes
+}
+
 func (c *Collection) getIterator(reverted bool) *CollectionIterator {
+	return c.getIteratorWithTxn(c.db.badger.NewTransaction(false), reverted, false)
+}
+
+// getIteratorWithTxn builds an iterator on the given transaction.
+// If sharedTxn is true the transaction is not discarded when the iterator is closed.
+func (c *Collection) getIteratorWithTxn(txn *badger.Txn, reverted, sharedTxn bool) *CollectionIterator {
 	iterOptions := badger.DefaultIteratorOptions
 	iterOptions.Reverse = reverted
 
-	txn := c.db.badger.NewTransaction(false)
 	badgerIter := txn.NewIterator(iterOptions)
 
 	tmpPrefix := c.buildDBKey("")
@@ -586,6 +853,7 @@ func (c *Collection) getIterator(reverted bool) *CollectionIterator {
 	baseIterator := &baseIterator{
 		txn:        txn,
 		badgerIter: badgerIter,
+		sharedTxn:  sharedTxn,
 	}
 
 	return &CollectionIterator{
@@ -602,6 +870,41 @@ func (c *Collection) GetIterator() *CollectionIterator {
 	return iter
 }
 
+// IterateRange returns an iterator over the documents with an id between from and to.
+// The iteration stops at the upper bound which is included unless IterOptions.ExcludeTo is set.
+// An empty from starts at the first document and an empty to goes up to the last one.
+func (c *Collection) IterateRange(from, to string, opts IterOptions) *CollectionIterator {
+	txn := c.db.badger.NewTransaction(false)
+
+	iterOptions := badger.DefaultIteratorOptions
+	iterOptions.PrefetchValues = !opts.KeysOnly
+	badgerIter := txn.NewIterator(iterOptions)
+
+	iter := &CollectionIterator{
+		baseIterator: &baseIterator{
+			txn:        txn,
+			badgerIter: badgerIter,
+		},
+		c:         c,
+		colPrefix: c.buildDBKey(""),
+		bounds: &iterBounds{
+			opts:   opts,
+			prefix: c.buildDBKey(opts.Prefix),
+		},
+	}
+	if to != "" {
+		iter.bounds.to = c.buildDBKey(to)
+	}
+
+	start := from
+	if opts.Prefix > start {
+		start = opts.Prefix
+	}
+	badgerIter.Seek(c.buildDBKey(start))
+
+	return iter
+}
+
 // GetRevertedIterator does same as above but work in the oposite way
 func (c *Collection) GetRevertedIterator() *CollectionIterator {
 	iter := c.getIterator(true)
@@ -609,8 +912,18 @@ func (c *Collection) GetRevertedIterator() *CollectionIterator {
 	return iter
 }
 
-// addOperation add an operation to the existing Transactio pointer
-func (b *Batch) addOperation(id string, content interface{}, delete, cleanHistory bool) error {
+// addOperation add an operation to the existing Transactio pointer.
+// The before hooks of the collection are called here.
+func (b *Batch) addOperation(id string, content interface{}, delete, cleanHistory bool) (err error) {
+	if delete {
+		err = b.c.hooks.runBeforeDelete(id)
+	} else {
+		content, err = b.c.hooks.runBeforePut(id, content)
+	}
+	if err != nil {
+		return err
+	}
+
 	op, err := b.c.buildOperation(id, content, delete, cleanHistory)
 	if err != nil {
 		return err
@@ -0,0 +1,132 @@
+/*
+Package compress provides the compression algorithms which can be applied to
+the documents before encryption.
+*/
+package compress
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Those constants defines the supported algorithms.
+// The value is saved as the first byte of every record to know how to decompress it.
+const (
+	None byte = iota
+	Snappy
+	Zstd
+)
+
+var (
+	// ErrUnknownAlgorithm is returned when the algorithm is not supported
+	ErrUnknownAlgorithm = fmt.Errorf("unknown compression algorithm")
+	// ErrMissingHeader is returned when the value to decode is empty
+	ErrMissingHeader = fmt.Errorf("the value has no compression header")
+)
+
+var (
+	zstdEncoders     = map[int]*zstd.Encoder{}
+	zstdEncodersLock sync.Mutex
+
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// Compress compresses the content with the given algorithm.
+// The level is only used by Zstd, zero means the default level.
+func Compress(algorithm byte, level int, content []byte) ([]byte, error) {
+	switch algorithm {
+	case None:
+		return content, nil
+	case Snappy:
+		return snappy.Encode(nil, content), nil
+	case Zstd:
+		encoder, err := getZstdEncoder(level)
+		if err != nil {
+			return nil, err
+		}
+		return encoder.EncodeAll(content, nil), nil
+	}
+
+	return nil, ErrUnknownAlgorithm
+}
+
+// Decompress returns the clear content compressed with the given algorithm
+func Decompress(algorithm byte, content []byte) ([]byte, error) {
+	switch algorithm {
+	case None:
+		return content, nil
+	case Snappy:
+		return snappy.Decode(nil, content)
+	case Zstd:
+		return zstdDecoder.DecodeAll(content, nil)
+	}
+
+	return nil, ErrUnknownAlgorithm
+}
+
+// Encode compresses the content and returns it after a header byte which gives the
+// algorithm used. The content is not compressed if it does not get smaller.
+func Encode(algorithm byte, level int, content []byte) ([]byte, error) {
+	compressed, err := Compress(algorithm, level, content)
+	if err != nil {
+		return nil, err
+	}
+
+	if algorithm != None && len(compressed) >= len(content) {
+		algorithm, compressed = None, content
+	}
+
+	ret := make([]byte, len(compressed)+1)
+	ret[0] = algorithm
+	copy(ret[1:], compressed)
+	return ret, nil
+}
+
+// Decode reads the header byte of the value and returns the decompressed content
+func Decode(value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return nil, ErrMissingHeader
+	}
+
+	return Decompress(value[0], value[1:])
+}
+
+// CheckAlgorithm returns an error if the algorithm is not supported
+func CheckAlgorithm(algorithm byte) error {
+	if algorithm > Zstd {
+		return ErrUnknownAlgorithm
+	}
+	return nil
+}
+
+// getZstdEncoder returns an encoder for the given level.
+// The encoders are kept because they are expensive to build and safe for concurrent use.
+func getZstdEncoder(level int) (*zstd.Encoder, error) {
+	zstdEncodersLock.Lock()
+	defer zstdEncodersLock.Unlock()
+
+	if encoder, ok := zstdEncoders[level]; ok {
+		return encoder, nil
+	}
+
+	options := []zstd.EOption{}
+	if level != 0 {
+		// Maps the usual zstd levels on the speeds the encoder provides
+		speed := zstd.SpeedDefault
+		if level < 3 {
+			speed = zstd.SpeedFastest
+		}
+		options = append(options, zstd.WithEncoderLevel(speed))
+	}
+
+	encoder, err := zstd.NewWriter(nil, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	zstdEncoders[level] = encoder
+	return encoder, nil
+}
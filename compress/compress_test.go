@@ -0,0 +1,84 @@
+package compress
+
+import (
+	"bytes"
+	"testing"
+)
+
+var (
+	content = bytes.Repeat([]byte("the content must be compressed "), 100)
+)
+
+func TestCompressDecompress(t *testing.T) {
+	for _, algorithm := range []byte{None, Snappy, Zstd} {
+		for _, level := range []int{0, 1, 19} {
+			compressed, err := Compress(algorithm, level, content)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if algorithm != None && len(compressed) >= len(content) {
+				t.Fatalf("algorithm %d level %d: the content is not compressed", algorithm, level)
+			}
+
+			var clear []byte
+			clear, err = Decompress(algorithm, compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(clear, content) {
+				t.Fatalf("algorithm %d level %d: the decompressed content is not the same as the original", algorithm, level)
+			}
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, algorithm := range []byte{None, Snappy, Zstd} {
+		encoded, err := Encode(algorithm, 0, content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encoded[0] != algorithm {
+			t.Fatalf("expected the header %d but got %d", algorithm, encoded[0])
+		}
+
+		var clear []byte
+		clear, err = Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(clear, content) {
+			t.Fatalf("algorithm %d: the decoded content is not the same as the original", algorithm)
+		}
+	}
+
+	// The content which does not get smaller is saved as is
+	encoded, err := Encode(Snappy, 0, []byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, []byte{None, 'a'}) {
+		t.Fatalf("expected the content not to be compressed but got %v", encoded)
+	}
+
+	_, err = Decode(nil)
+	if err != ErrMissingHeader {
+		t.Fatalf("expected %v but got %v", ErrMissingHeader, err)
+	}
+}
+
+func TestUnknownAlgorithm(t *testing.T) {
+	_, err := Compress(Zstd+1, 0, content)
+	if err != ErrUnknownAlgorithm {
+		t.Fatalf("expected %v but got %v", ErrUnknownAlgorithm, err)
+	}
+	_, err = Decompress(Zstd+1, content)
+	if err != ErrUnknownAlgorithm {
+		t.Fatalf("expected %v but got %v", ErrUnknownAlgorithm, err)
+	}
+	if CheckAlgorithm(Zstd+1) != ErrUnknownAlgorithm {
+		t.Fatalf("the algorithm must be unknown")
+	}
+}
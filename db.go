@@ -17,10 +17,12 @@ import (
 	"io"
 	"math"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/alexandrestein/gotinydb/blevestore"
 	"github.com/alexandrestein/gotinydb/cipher"
+	"github.com/alexandrestein/gotinydb/compress"
 	"github.com/alexandrestein/gotinydb/transaction"
 	"github.com/blevesearch/bleve"
 	"github.com/blevesearch/bleve/mapping"
@@ -48,6 +50,11 @@ type (
 		Collections []*Collection
 
 		writeChan chan *transaction.Transaction
+
+		// sequences are the sequences in use, they are released when the database is closed
+		sequences *sequenceStore
+		// commitLock serializes the commits of the writer and of the transactions of *DB.Update
+		commitLock *sync.Mutex
 	}
 
 	dbElement struct {
@@ -79,6 +86,10 @@ func Open(path string, configKey [32]byte) (db *DB, err error) {
 	options.NumVersionsToKeep = math.MaxInt32
 
 	db.writeChan = make(chan *transaction.Transaction, 1000)
+	db.sequences = &sequenceStore{
+		sequences: map[string]*Sequence{},
+	}
+	db.commitLock = new(sync.Mutex)
 
 	db.startBackgroundLoops()
 
@@ -99,6 +110,11 @@ func Open(path string, configKey [32]byte) (db *DB, err error) {
 		if err != nil {
 			return nil, err
 		}
+
+		err = db.cleanFileStagings()
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return db, nil
@@ -142,27 +158,40 @@ func (d *DB) Use(colName string) (col *Collection, err error) {
 	return
 }
 
+// getCollection returns an existing collection without creating it
+func (d *DB) getCollection(colName string) (*Collection, error) {
+	for _, savedCol := range d.Collections {
+		if savedCol.Name == colName {
+			if savedCol.db == nil {
+				savedCol.db = d
+			}
+			return savedCol, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
 // Close close the database and all subcomposants. It returns the error if any
 func (d *DB) Close() (err error) {
 	d.cancel()
 
-	// In case of any error
-	defer func() {
-		if err != nil {
-			d.badger.Close()
+	// Everything is closed even if an error occurs, the first error is returned
+	keepErr := func(closeErr error) {
+		if err == nil {
+			err = closeErr
 		}
-	}()
+	}
+
+	keepErr(d.releaseSequences())
 
 	for _, col := range d.Collections {
-		for _, i := range col.BleveIndexes {
-			err = i.close()
-			if err != nil {
-				return err
-			}
+		for _, i := range col.getBleveIndexes() {
+			keepErr(i.close())
 		}
 	}
 
-	return d.badger.Close()
+	keepErr(d.badger.Close())
+	return
 }
 
 // Backup perform a full backup of the database.
@@ -265,33 +294,141 @@ func (d *DB) goRoutineLoopForWrites() {
 		default:
 		}
 
-		err := d.badger.Update(func(txn *badger.Txn) error {
-			for _, transaction := range waitingWrites {
-				for _, op := range transaction.Operations {
-					var err error
-					if op.Delete {
-						err = txn.Delete(op.DBKey)
-					} else if op.CleanHistory {
-						err = txn.SetWithDiscard(op.DBKey, cipher.Encrypt(d.PrivateKey, op.DBKey, op.Value), 0)
-					} else {
-						err = txn.Set(op.DBKey, cipher.Encrypt(d.PrivateKey, op.DBKey, op.Value))
-					}
-					// Returns the write error to the caller
-					if err != nil {
-						go d.nonBlockingResponseChan(transaction, err)
-					}
+		d.writeTransactions(waitingWrites)
+	}
+}
+
+// writeTransactions commits the transactions in order.
+// The transactions with checks are committed alone to be able to cancel them
+// without cancelling the others.
+func (d *DB) writeTransactions(waitingWrites []*transaction.Transaction) {
+	start := 0
+	for i, tr := range waitingWrites {
+		if !tr.HasChecks() {
+			continue
+		}
+
+		d.commitTransactions(waitingWrites[start:i])
+		d.commitTransactions(waitingWrites[i : i+1])
+		start = i + 1
+	}
+	d.commitTransactions(waitingWrites[start:])
+}
+
+// commitTransactions writes the transactions in one commit.
+// Any error cancels the commit. If there are many transactions they are written again
+// one by one, this way only the callers of the failing transactions get the error.
+func (d *DB) commitTransactions(waitingWrites []*transaction.Transaction) {
+	if len(waitingWrites) == 0 {
+		return
+	}
+
+	d.commitLock.Lock()
+	err := d.badger.Update(func(txn *badger.Txn) error {
+		for _, tr := range waitingWrites {
+			for _, op := range tr.Operations {
+				err := d.writeOperation(txn, op)
+				if err != nil {
+					return err
+				}
+			}
+
+			if tr.HasChecks() {
+				err := tr.RunChecks(txn)
+				if err != nil {
+					return err
 				}
 			}
-			return nil
-		})
+		}
+		return nil
+	})
+	d.commitLock.Unlock()
 
-		// Dispatch the commit response to all callers
-		for _, op := range waitingWrites {
-			go d.nonBlockingResponseChan(op, err)
+	if err != nil && len(waitingWrites) > 1 {
+		for _, tr := range waitingWrites {
+			d.commitTransactions([]*transaction.Transaction{tr})
 		}
+		return
+	}
+
+	if err == badger.ErrConflict {
+		err = ErrConflict
+	}
+
+	// Dispatch the commit response to all callers
+	for _, tr := range waitingWrites {
+		go d.nonBlockingResponseChan(tr, err)
 	}
 }
 
+// writeOperation does the write of the operation inside the given badger transaction
+func (d *DB) writeOperation(txn *badger.Txn, op *transaction.Operation) (err error) {
+	// The operations without key only run their hook
+	if op.DBKey == nil {
+		if op.Hook != nil {
+			return op.Hook(txn)
+		}
+		return nil
+	}
+
+	if op.Delete {
+		err = txn.Delete(op.DBKey)
+	} else {
+		// The compression algorithm is saved in the header byte of the compressible values
+		// to be able to read records with different compression settings
+		var value []byte
+		value, err = compressValue(op)
+		if err != nil {
+			return err
+		}
+
+		// The user meta tells the values with the header from the values saved without it
+		var userMeta byte
+		if op.Compressible {
+			userMeta = userMetaCompressionHeader
+		}
+
+		if op.CleanHistory {
+			err = txn.SetWithDiscard(op.DBKey, cipher.Encrypt(d.PrivateKey, op.DBKey, value), userMeta)
+		} else {
+			err = txn.SetWithMeta(op.DBKey, cipher.Encrypt(d.PrivateKey, op.DBKey, value), userMeta)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if op.Hook != nil {
+		return op.Hook(txn)
+	}
+	return nil
+}
+
+// chainHooks returns a hook which calls the given hooks in order, nil hooks are skipped.
+// It's also used for the checks of the operations.
+func chainHooks(hooks ...func(txn *badger.Txn) error) func(txn *badger.Txn) error {
+	var ret func(txn *badger.Txn) error
+	for _, hook := range hooks {
+		if hook == nil {
+			continue
+		}
+		if ret == nil {
+			ret = hook
+			continue
+		}
+
+		first, second := ret, hook
+		ret = func(txn *badger.Txn) error {
+			err := first(txn)
+			if err != nil {
+				return err
+			}
+			return second(txn)
+		}
+	}
+	return ret
+}
+
 func (d *DB) nonBlockingResponseChan(tx *transaction.Transaction, err error) {
 	select {
 	case tx.ResponseChan <- err:
@@ -300,8 +437,57 @@ func (d *DB) nonBlockingResponseChan(tx *transaction.Transaction, err error) {
 	}
 }
 
+// decryptData returns the clear content or ErrIntegrity if the authentication fails
 func (d *DB) decryptData(dbKey, encryptedData []byte) (clear []byte, err error) {
-	return cipher.Decrypt(d.PrivateKey, dbKey, encryptedData)
+	clear, err = cipher.Decrypt(d.PrivateKey, dbKey, encryptedData)
+	if err != nil {
+		return nil, ErrIntegrity
+	}
+	return clear, nil
+}
+
+// decryptItem reads the item value and returns it as clear text
+func (d *DB) decryptItem(item *badger.Item) (clear []byte, err error) {
+	var encrypted []byte
+	encrypted, err = item.ValueCopy(encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.decryptData(item.Key(), encrypted)
+}
+
+// decryptAndDecompressItem reads the value of a document and returns it as clear text
+func (d *DB) decryptAndDecompressItem(item *badger.Item) (clear []byte, err error) {
+	clear, err = d.decryptItem(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return decompressDocument(item.UserMeta(), clear)
+}
+
+// decompressDocument reads the compression header of the documents saved with one.
+// The documents saved before the compression was added have no header and are returned as is.
+func decompressDocument(userMeta byte, clear []byte) ([]byte, error) {
+	if userMeta&userMetaCompressionHeader == 0 {
+		return clear, nil
+	}
+	return compress.Decode(clear)
+}
+
+// userMetaCompressionHeader is set in the user meta of the values which start with
+// the compression header
+const userMetaCompressionHeader byte = 1
+
+// compressValue adds the compression header to the compressible values and
+// compresses them if needed. The other values are returned as is.
+func compressValue(op *transaction.Operation) ([]byte, error) {
+	if !op.Compressible {
+		return op.Value, nil
+	}
+
+	return compress.Encode(op.Compression, op.CompressionLevel, op.Value)
 }
 
 // saveConfig save the database configuration with collections and indexes
@@ -368,6 +554,8 @@ func (d *DB) loadConfig() error {
 	db.ctx, db.cancel = context.WithCancel(context.Background())
 	db.writeChan = d.writeChan
 	db.path = d.path
+	db.sequences = d.sequences
+	db.commitLock = d.commitLock
 
 	*d = *db
 
@@ -378,6 +566,13 @@ func (d *DB) loadConfig() error {
 
 func (d *DB) loadCollections() (err error) {
 	for _, col := range d.Collections {
+		col.db = d
+
+		err = col.loadSchema()
+		if err != nil {
+			return
+		}
+
 		for _, index := range col.BleveIndexes {
 			indexPrefix := make([]byte, len(index.Prefix))
 			copy(indexPrefix, index.Prefix)
@@ -387,11 +582,18 @@ func (d *DB) loadCollections() (err error) {
 				return
 			}
 		}
+
+		// Apply the index work which was saved but not done before the last stop
+		err = col.replayIndexJournal()
+		if err != nil {
+			return
+		}
 	}
 	return
 }
 
-// DeleteCollection removes every document and indexes and the collection itself
+// DeleteCollection removes every document and indexes and the collection itself.
+// The referential integrity doesn't protect the documents of the deleted collection.
 func (d *DB) DeleteCollection(colName string) {
 	var col *Collection
 	for i, tmpCol := range d.Collections {
@@ -411,9 +613,39 @@ func (d *DB) DeleteCollection(colName string) {
 		index.delete()
 	}
 
+	// The documents of the collection don't protect the referenced ones anymore
+	if col.RefIntegrity {
+		col.clearRefs()
+	}
+
+	// The documents are deleted even if they are referenced, the references to them
+	// must not protect the documents of a new collection with the same name
+	d.deleteRefsTo(colName)
+
 	d.deletePrefix(col.Prefix)
 }
 
+// prefixDeleteOperations returns the operations which delete the keys starting with the prefix
+func (d *DB) prefixDeleteOperations(prefix []byte) ([]*transaction.Operation, error) {
+	ops := []*transaction.Operation{}
+	err := d.badger.View(func(txn *badger.Txn) error {
+		opt := badger.DefaultIteratorOptions
+		opt.PrefetchValues = false
+		iter := txn.NewIterator(opt)
+		defer iter.Close()
+
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			ops = append(ops, transaction.NewOperation("", nil, iter.Item().KeyCopy(nil), nil, true, false))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ops, nil
+}
+
 func (d *DB) deletePrefix(prefix []byte) {
 	// Wait for write to be done in case any
 	time.Sleep(time.Millisecond * 500)
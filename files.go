@@ -3,12 +3,13 @@ package gotinydb
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
 	"time"
 
-	"github.com/alexandrestein/gotinydb/cipher"
 	"github.com/alexandrestein/gotinydb/transaction"
 	"github.com/dgraph-io/badger"
 	"golang.org/x/crypto/blake2b"
@@ -22,7 +23,21 @@ type (
 		Size         int64
 		LastModified time.Time
 		ChuckSize    int
-		inWrite      bool
+		// Version identifies the chunks of the content, it changes on every *DB.PutFile.
+		// It's empty for the files saved before the versions, their chunks follow the metadata.
+		Version string
+		// Checksum is the hexadecimal BLAKE2b-256 hash of the content. It can be used as ETag.
+		// It's empty for the files saved before the checksums and while a writer is open.
+		Checksum string
+		inWrite  bool
+	}
+
+	// FileReaderOptions defines the options of *DB.GetFileReaderWithOptions
+	FileReaderOptions struct {
+		// VerifyChecksum makes the reader check the size and the checksum of the content
+		// when the end of the file is reached. The reader returns ErrIntegrity instead of io.EOF
+		// if they don't match. Only the files read from the start without seeking are checked.
+		VerifyChecksum bool
 	}
 
 	readWriter struct {
@@ -31,6 +46,20 @@ type (
 		currentPosition int64
 		txn             *badger.Txn
 		writer          bool
+
+		// verifier hashes the content read when the checksum is verified
+		verifier *fileVerifier
+		// written hashes the content given to Write while the writes follow each other
+		// from the start of the file
+		written *fileVerifier
+	}
+
+	fileVerifier struct {
+		hash hash.Hash
+		// position is the number of bytes given to the hash
+		position int64
+		// broken is true if the content is not read in order
+		broken bool
 	}
 
 	// Reader define a simple object to read parts of the file
@@ -51,18 +80,24 @@ type (
 	}
 )
 
-// PutFile let caller insert large element into the database via a reader interface
+// PutFile let caller insert large element into the database via a reader interface.
+// The content is written in new chunks and the metadata is switched to them once they
+// are all saved. The readers see the previous content until the end and nothing changes
+// if the write fails.
 func (d *DB) PutFile(id string, name string, reader io.Reader) (n int, err error) {
-	d.DeleteFile(id)
-
 	meta := d.buildMeta(id, name)
-	meta.inWrite = true
-
-	// Set the meta
-	err = d.putFileMeta(meta)
+	meta.Version, err = NewID()
 	if err != nil {
-		return
+		return 0, err
 	}
+	checksum, _ := blake2b.New256(nil)
+
+	// The staging chunks are removed if the write does not go to the end
+	defer func() {
+		if err != nil {
+			d.deleteFileKeys(d.buildFilePrefix(meta.chunksID(), -1), d.buildFilePrefix(meta.chunksID(), 1))
+		}
+	}()
 
 	// Track the numbers of chunks
 	nChunk := 1
@@ -85,8 +120,9 @@ func (d *DB) PutFile(id string, name string, reader io.Reader) (n int, err error
 		buff = buff[:nWritten]
 
 		n = n + nWritten
+		checksum.Write(buff)
 
-		err = d.writeFileChunk(id, nChunk, buff)
+		err = d.writeFileChunk(meta.chunksID(), nChunk, buff)
 		if err != nil {
 			return n, err
 		}
@@ -94,20 +130,30 @@ func (d *DB) PutFile(id string, name string, reader io.Reader) (n int, err error
 		// Increment the chunk counter
 		nChunk++
 	}
+	if err != nil && err != io.EOF {
+		return
+	}
 
 	meta.Size = int64(n)
+	meta.Checksum = hex.EncodeToString(checksum.Sum(nil))
 	meta.LastModified = time.Now()
-	meta.inWrite = false
-	err = d.putFileMeta(meta)
+	// The file is switched to the new chunks in one write
+	var oldMeta *FileMeta
+	oldMeta, err = d.replaceFileMeta(meta)
 	if err != nil {
 		return
 	}
 
+	// The old chunks are not used anymore, if the database stops before they are
+	// removed they are cleaned at the next opening
+	d.deleteFileKeys(d.buildFilePrefix(oldMeta.chunksID(), -1), d.buildFilePrefix(oldMeta.chunksID(), 1))
+
 	err = nil
 	return
 }
 
-func (d *DB) writeFileChunk(id string, chunk int, content []byte) (err error) {
+// writeFileChunk saves the chunk of the given chunks id, see *FileMeta.chunksID
+func (d *DB) writeFileChunk(chunksID string, chunk int, content []byte) (err error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -117,7 +163,7 @@ func (d *DB) writeFileChunk(id string, chunk int, content []byte) (err error) {
 
 	tx := transaction.New(ctx)
 	tx.AddOperation(
-		transaction.NewOperation("", nil, d.buildFilePrefix(id, chunk), content, false, true),
+		transaction.NewOperation("", nil, d.buildFilePrefix(chunksID, chunk), content, false, true),
 	)
 	// Run the insertion
 	select {
@@ -137,37 +183,46 @@ func (d *DB) writeFileChunk(id string, chunk int, content []byte) (err error) {
 
 func (d *DB) getFileMeta(id, name string) (meta *FileMeta, err error) {
 	err = d.badger.View(func(txn *badger.Txn) (err error) {
-		metaID := d.buildFilePrefix(id, 0)
+		meta, err = d.getFileMetaTxn(txn, id, name)
+		return
+	})
+	if err != nil {
+		return
+	}
+	return
+}
 
-		var item *badger.Item
-		item, err = txn.Get(metaID)
-		if err != nil {
-			if err == badger.ErrKeyNotFound {
-				err = nil
-				meta = d.buildMeta(id, name)
-				return
-			}
-			return
-		}
+// getFileMetaTxn returns the metadata of the file as seen by the transaction.
+// It returns a new metadata if the file does not exist.
+func (d *DB) getFileMetaTxn(txn *badger.Txn, id, name string) (meta *FileMeta, err error) {
+	metaID := d.buildFilePrefix(id, 0)
 
-		var valAsEncryptedBytes []byte
-		valAsEncryptedBytes, err = item.ValueCopy(valAsEncryptedBytes)
-		if err != nil {
-			return
+	var item *badger.Item
+	item, err = txn.Get(metaID)
+	if err != nil {
+		if err == badger.ErrKeyNotFound {
+			return d.buildMeta(id, name), nil
 		}
+		return
+	}
 
-		var valAsBytes []byte
-		valAsBytes, err = cipher.Decrypt(d.PrivateKey, item.Key(), valAsEncryptedBytes)
-		if err != nil {
-			return
-		}
+	var valAsEncryptedBytes []byte
+	valAsEncryptedBytes, err = item.ValueCopy(valAsEncryptedBytes)
+	if err != nil {
+		return
+	}
 
-		meta = new(FileMeta)
-		return json.Unmarshal(valAsBytes, meta)
-	})
+	var valAsBytes []byte
+	valAsBytes, err = d.decryptData(item.Key(), valAsEncryptedBytes)
 	if err != nil {
 		return
 	}
+
+	meta = new(FileMeta)
+	err = json.Unmarshal(valAsBytes, meta)
+	if err != nil {
+		return nil, err
+	}
 	return
 }
 
@@ -212,40 +267,153 @@ func (d *DB) putFileMeta(meta *FileMeta) (err error) {
 	return
 }
 
-// ReadFile write file content into the given writer
+// replaceFileMeta saves the metadata and returns the one it replaces. The previous metadata
+// is read by the writer in the same transaction, the chunks it uses are not used anymore
+// even if other writes of the same file are done at the same time.
+func (d *DB) replaceFileMeta(meta *FileMeta) (oldMeta *FileMeta, err error) {
+	metaID := d.buildFilePrefix(meta.ID, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var metaAsBytes []byte
+	metaAsBytes, err = json.Marshal(meta)
+	if err != nil {
+		return
+	}
+
+	// The operation has no key, the metadata is written by the hook once the previous one is read
+	op := transaction.NewOperation("", nil, nil, nil, false, false)
+	op.Hook = func(txn *badger.Txn) (err error) {
+		oldMeta, err = d.getFileMetaTxn(txn, meta.ID, meta.Name)
+		if err != nil {
+			return
+		}
+		return d.writeOperation(txn, transaction.NewOperation("", nil, metaID, metaAsBytes, false, false))
+	}
+
+	tx := transaction.New(ctx)
+	tx.AddOperation(op)
+	// Run the insertion
+	select {
+	case d.writeChan <- tx:
+	case <-d.ctx.Done():
+		return nil, d.ctx.Err()
+	}
+	// And wait for the end of the insertion
+	select {
+	case err = <-tx.ResponseChan:
+	case <-tx.Ctx.Done():
+		err = tx.Ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// ReadFile write file content into the given writer.
+// It returns ErrIntegrity if a chunk is missing or was modified.
 func (d *DB) ReadFile(id string, writer io.Writer) error {
 	return d.badger.View(func(txn *badger.Txn) error {
-		storeID := d.buildFilePrefix(id, -1)
+		// The metadata and the chunks are read from the same snapshot
+		meta, err := d.getFileMetaTxn(txn, id, "")
+		if err != nil {
+			return err
+		}
 
-		opt := badger.DefaultIteratorOptions
-		opt.PrefetchSize = 3
-		opt.PrefetchValues = true
+		return d.readFileChunks(txn, meta, func(chunk []byte) error {
+			_, err := writer.Write(chunk)
+			return err
+		})
+	})
+}
 
-		it := txn.NewIterator(opt)
-		defer it.Close()
+// VerifyFile reads the whole file and returns ErrIntegrity if a chunk is missing or was
+// modified, or if the size or the checksum of the content are not the ones of the metadata.
+// The files saved without checksum only have their chunks and their size checked.
+func (d *DB) VerifyFile(id string) error {
+	return d.badger.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(d.buildFilePrefix(id, 0))
+		if err == badger.ErrKeyNotFound {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
 
-		for it.Seek(d.buildFilePrefix(id, 1)); it.ValidForPrefix(storeID); it.Next() {
-			var err error
-			var valAsEncryptedBytes []byte
-			valAsEncryptedBytes, err = it.Item().ValueCopy(valAsEncryptedBytes)
-			if err != nil {
-				return err
-			}
+		meta, err := d.getFileMetaTxn(txn, id, "")
+		if err != nil {
+			return err
+		}
 
-			var valAsBytes []byte
-			valAsBytes, err = cipher.Decrypt(d.PrivateKey, it.Item().Key(), valAsEncryptedBytes)
-			if err != nil {
-				return err
-			}
+		verifier := newFileVerifier()
+		err = d.readFileChunks(txn, meta, func(chunk []byte) error {
+			verifier.write(0, chunk)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
 
-			_, err = writer.Write(valAsBytes)
-			if err != nil {
-				return err
-			}
+		return verifier.verify(meta)
+	})
+}
+
+// readFileChunks calls fn with the content of every chunk in order.
+// It returns ErrIntegrity if a chunk is missing.
+func (d *DB) readFileChunks(txn *badger.Txn, meta *FileMeta, fn func(chunk []byte) error) error {
+	chunksID := meta.chunksID()
+	storeID := d.buildFilePrefix(chunksID, -1)
+
+	opt := badger.DefaultIteratorOptions
+	opt.PrefetchSize = 3
+	opt.PrefetchValues = true
+
+	it := txn.NewIterator(opt)
+	defer it.Close()
+
+	nChunk := 1
+	for it.Seek(d.buildFilePrefix(chunksID, 1)); it.ValidForPrefix(storeID); it.Next() {
+		if !bytes.Equal(it.Item().Key(), d.buildFilePrefix(chunksID, nChunk)) {
+			return ErrIntegrity
 		}
+		nChunk++
 
+		valAsEncryptedBytes, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		var valAsBytes []byte
+		valAsBytes, err = d.decryptData(it.Item().Key(), valAsEncryptedBytes)
+		if err != nil {
+			return err
+		}
+
+		err = fn(valAsBytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fileChecksum returns the checksum of the content of the file as it is saved
+func (d *DB) fileChecksum(meta *FileMeta) (checksum string, err error) {
+	err = d.badger.View(func(txn *badger.Txn) error {
+		verifier := newFileVerifier()
+		err := d.readFileChunks(txn, meta, func(chunk []byte) error {
+			verifier.write(0, chunk)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		checksum = hex.EncodeToString(verifier.hash.Sum(nil))
 		return nil
 	})
+	return
 }
 
 // GetFileReader returns a struct to provide simple reading partial of big files.
@@ -255,6 +423,20 @@ func (d *DB) GetFileReader(id string) (Reader, error) {
 	return Reader(rw), err
 }
 
+// GetFileReaderWithOptions does the same as *DB.GetFileReader with the given options
+func (d *DB) GetFileReaderWithOptions(id string, options *FileReaderOptions) (Reader, error) {
+	rw, err := d.newReadWriter(id, "", false)
+	if err != nil {
+		return nil, err
+	}
+
+	if options != nil && options.VerifyChecksum {
+		rw.verifier = newFileVerifier()
+	}
+
+	return Reader(rw), nil
+}
+
 // GetFileWriter returns a struct to provide simple partial write of big files.
 // The default position is at the end of the file.
 func (d *DB) GetFileWriter(id, name string) (Writer, error) {
@@ -268,6 +450,7 @@ func (d *DB) GetFileWriter(id, name string) (Writer, error) {
 	}
 
 	rw.meta.inWrite = true
+	rw.written = newFileVerifier()
 	err = d.putFileMeta(rw.meta)
 	if err != nil {
 		return nil, err
@@ -279,13 +462,29 @@ func (d *DB) GetFileWriter(id, name string) (Writer, error) {
 
 // DeleteFile deletes every chunks of the given file ID
 func (d *DB) DeleteFile(id string) (err error) {
+	meta, err := d.getFileMeta(id, "")
+	if err != nil {
+		return err
+	}
+
+	// The metadata is removed first, with the chunks of the files saved before the versions
+	err = d.deleteFileKeys(d.buildFilePrefix(id, -1), d.buildFilePrefix(id, 0))
+	if err != nil {
+		return err
+	}
+
+	if meta.Version == "" {
+		return nil
+	}
+	return d.deleteFileKeys(d.buildFilePrefix(meta.chunksID(), -1), d.buildFilePrefix(meta.chunksID(), 1))
+}
+
+// deleteFileKeys deletes the keys with the given prefix starting at the given key
+func (d *DB) deleteFileKeys(prefix, start []byte) (err error) {
 	listOfTx := []*transaction.Transaction{}
 
 	// Open a read transaction to get every IDs
 	return d.badger.View(func(txn *badger.Txn) error {
-		// Build the file prefix
-		storeID := d.buildFilePrefix(id, -1)
-
 		// Defines the iterator options to get only IDs
 		opt := badger.DefaultIteratorOptions
 		opt.PrefetchValues = false
@@ -299,7 +498,7 @@ func (d *DB) DeleteFile(id string) (err error) {
 		defer cancel()
 
 		// Go the the first file chunk
-		for it.Seek(storeID); it.ValidForPrefix(storeID); it.Next() {
+		for it.Seek(start); it.ValidForPrefix(prefix); it.Next() {
 			// Copy the store key
 			var key []byte
 			key = it.Item().KeyCopy(key)
@@ -328,6 +527,83 @@ func (d *DB) DeleteFile(id string) (err error) {
 	})
 }
 
+// cleanFileStagings removes the chunks which are not used by any file. They are left by
+// *DB.PutFile when it's stopped before the end or before the old chunks are removed.
+func (d *DB) cleanFileStagings() error {
+	// used are the derived ids of the metadata and of the chunks they use
+	used := map[string]bool{}
+	// chunks are the derived ids of all the chunks
+	chunks := map[string]bool{}
+	// unreadable is true if a metadata can't be decrypted or decoded
+	unreadable := false
+
+	err := d.badger.View(func(txn *badger.Txn) error {
+		opt := badger.DefaultIteratorOptions
+		opt.PrefetchValues = false
+
+		it := txn.NewIterator(opt)
+		defer it.Close()
+
+		prefix := []byte{prefixFiles}
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			key := item.Key()
+			if len(key) < 1+blake2b.Size {
+				continue
+			}
+			derivedID := string(key[1 : 1+blake2b.Size])
+
+			if len(key) != 2+blake2b.Size || key[len(key)-1] != 0 {
+				chunks[derivedID] = true
+				continue
+			}
+
+			valAsEncryptedBytes, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			used[derivedID] = true
+
+			// The record is skipped, it must not prevent the opening of the database
+			valAsBytes, err := d.decryptData(item.Key(), valAsEncryptedBytes)
+			if err != nil {
+				unreadable = true
+				continue
+			}
+			meta := new(FileMeta)
+			err = json.Unmarshal(valAsBytes, meta)
+			if err != nil {
+				unreadable = true
+				continue
+			}
+
+			used[string(d.buildFilePrefix(meta.chunksID(), -1)[1:])] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Nothing is removed if a metadata can't be read because its chunks are not known
+	if unreadable {
+		return nil
+	}
+
+	for derivedID := range chunks {
+		if used[derivedID] {
+			continue
+		}
+
+		prefix := append([]byte{prefixFiles}, derivedID...)
+		err = d.deleteFileKeys(prefix, prefix)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (d *DB) buildFilePrefix(id string, chunkN int) []byte {
 	// Derive the ID to make sure no file ID overlap the other.
 	// Because the files are chunked it needs to have a stable prefix for reading
@@ -367,19 +643,38 @@ func (d *DB) newReadWriter(id, name string, writer bool) (_ *readWriter, err err
 	rw := new(readWriter)
 	rw.writer = writer
 
-	rw.meta, err = d.getFileMeta(id, name)
+	rw.db = d
+	rw.txn = d.badger.NewTransaction(false)
+
+	rw.meta, err = d.getFileMetaTxn(rw.txn, id, name)
 	if err != nil {
+		rw.txn.Discard()
 		return nil, err
 	}
 
-	rw.db = d
-	rw.txn = d.badger.NewTransaction(false)
-
 	return rw, nil
 }
 
 // Read implements the io.Reader interface
 func (r *readWriter) Read(p []byte) (n int, err error) {
+	position := r.currentPosition
+	n, err = r.read(p)
+	if r.verifier == nil {
+		return n, err
+	}
+
+	r.verifier.write(position, p[:n])
+	if err == io.EOF && !r.verifier.broken {
+		verifyErr := r.verifier.verify(r.meta)
+		if verifyErr != nil {
+			return n, verifyErr
+		}
+	}
+
+	return n, err
+}
+
+func (r *readWriter) read(p []byte) (n int, err error) {
 	block, inside := r.getBlockAndInsidePosition(r.currentPosition)
 
 	opt := badger.DefaultIteratorOptions
@@ -392,8 +687,8 @@ func (r *readWriter) Read(p []byte) (n int, err error) {
 	buffer := bytes.NewBuffer(nil)
 	first := true
 
-	filePrefix := r.db.buildFilePrefix(r.meta.ID, -1)
-	for it.Seek(r.db.buildFilePrefix(r.meta.ID, block)); it.ValidForPrefix(filePrefix); it.Next() {
+	filePrefix := r.db.buildFilePrefix(r.meta.chunksID(), -1)
+	for it.Seek(r.db.buildFilePrefix(r.meta.chunksID(), block)); it.ValidForPrefix(filePrefix); it.Next() {
 		if it.Item().IsDeletedOrExpired() {
 			break
 		}
@@ -406,7 +701,7 @@ func (r *readWriter) Read(p []byte) (n int, err error) {
 		}
 
 		var valAsBytes []byte
-		valAsBytes, err = cipher.Decrypt(r.db.PrivateKey, it.Item().Key(), valAsEncryptedBytes)
+		valAsBytes, err = r.db.decryptData(it.Item().Key(), valAsEncryptedBytes)
 		if err != nil {
 			return 0, err
 		}
@@ -453,7 +748,7 @@ func (r *readWriter) ReadAt(p []byte, off int64) (n int, err error) {
 }
 
 func (r *readWriter) getExistingBlock(blockN int) (ret []byte, err error) {
-	chunkID := r.db.buildFilePrefix(r.meta.ID, blockN)
+	chunkID := r.db.buildFilePrefix(r.meta.chunksID(), blockN)
 	var item *badger.Item
 	item, err = r.txn.Get(chunkID)
 	if err != nil {
@@ -469,10 +764,24 @@ func (r *readWriter) getExistingBlock(blockN int) (ret []byte, err error) {
 		return nil, err
 	}
 
-	return cipher.Decrypt(r.db.PrivateKey, item.Key(), valAsEncryptedBytes)
+	return r.db.decryptData(item.Key(), valAsEncryptedBytes)
 }
 
+// Write implements the io.Writer interface
 func (r *readWriter) Write(p []byte) (n int, err error) {
+	position := r.currentPosition
+	n, err = r.write(p)
+	if err != nil {
+		// The content of the file is not known anymore
+		r.written.broken = true
+		return n, err
+	}
+
+	r.written.write(position, p)
+	return n, nil
+}
+
+func (r *readWriter) write(p []byte) (n int, err error) {
 	// Get a new transaction to be able to call write multiple times
 	defer r.afterWrite(len(p))
 
@@ -497,12 +806,12 @@ func (r *readWriter) Write(p []byte) (n int, err error) {
 			toWrite = append(toWrite, valAsBytes[existingAfterNewWriteStartPosition:]...)
 		}
 
-		return len(p), r.db.writeFileChunk(r.meta.ID, block, toWrite)
+		return len(p), r.db.writeFileChunk(r.meta.chunksID(), block, toWrite)
 	}
 
 	toWriteInTheFirstChunk := valAsBytes[:inside]
 	toWriteInTheFirstChunk = append(toWriteInTheFirstChunk, p[n:freeToWriteInThisChunk]...)
-	err = r.db.writeFileChunk(r.meta.ID, block, toWriteInTheFirstChunk)
+	err = r.db.writeFileChunk(r.meta.chunksID(), block, toWriteInTheFirstChunk)
 	if err != nil {
 		return n, err
 	}
@@ -519,16 +828,26 @@ newLoop:
 		done = true
 	}
 
-	nextToWrite := p[n:newEnd]
+	// The capacity is limited to not write after the end of p when appending the existing content
+	nextToWrite := p[n:newEnd:newEnd]
 	if done {
+		if len(nextToWrite) == 0 {
+			// The previous chunk ended exactly with the content
+			n = len(p)
+			return
+		}
+
 		valAsBytes, err = r.getExistingBlock(block)
 		if err != nil {
 			return 0, err
 		}
-		nextToWrite = append(nextToWrite, valAsBytes[len(nextToWrite):]...)
+		// The end of the existing chunk is kept if the new content is shorter
+		if len(valAsBytes) > len(nextToWrite) {
+			nextToWrite = append(nextToWrite, valAsBytes[len(nextToWrite):]...)
+		}
 	}
 
-	err = r.db.writeFileChunk(r.meta.ID, block, nextToWrite)
+	err = r.db.writeFileChunk(r.meta.chunksID(), block, nextToWrite)
 	if err != nil {
 		return n, err
 	}
@@ -549,8 +868,10 @@ func (r *readWriter) afterWrite(writenLength int) {
 	r.txn.Discard()
 	r.txn = r.db.badger.NewTransaction(false)
 
-	r.meta.Size += r.getWrittenSize()
+	r.meta.Size = r.getWrittenSize()
 	r.meta.LastModified = time.Now()
+	// The checksum is set back when the writer is closed
+	r.meta.Checksum = ""
 
 	r.currentPosition += int64(writenLength)
 
@@ -566,11 +887,11 @@ func (r *readWriter) getWrittenSize() (n int64) {
 	defer it.Close()
 
 	nbChunks := -1
-	blockesPrefix := r.db.buildFilePrefix(r.meta.ID, -1)
+	blockesPrefix := r.db.buildFilePrefix(r.meta.chunksID(), -1)
 	var item *badger.Item
 
 	var lastBlockItem *badger.Item
-	for it.Seek(r.db.buildFilePrefix(r.meta.ID, 1)); it.ValidForPrefix(blockesPrefix); it.Next() {
+	for it.Seek(r.db.buildFilePrefix(r.meta.chunksID(), 1)); it.ValidForPrefix(blockesPrefix); it.Next() {
 		item = it.Item()
 		if item.IsDeletedOrExpired() {
 			break
@@ -591,7 +912,7 @@ func (r *readWriter) getWrittenSize() (n int64) {
 	}
 
 	var valAsBytes []byte
-	valAsBytes, err = cipher.Decrypt(r.db.PrivateKey, item.Key(), encryptedValue)
+	valAsBytes, err = r.db.decryptData(lastBlockItem.Key(), encryptedValue)
 	if err != nil {
 		return
 	}
@@ -637,12 +958,63 @@ func (r *readWriter) Seek(offset int64, whence int) (n int64, err error) {
 func (r *readWriter) Close() (err error) {
 	if r.writer {
 		r.meta.inWrite = false
-		r.db.putFileMeta(r.meta)
+		if !r.written.broken && r.written.position == r.meta.Size {
+			r.meta.Checksum = hex.EncodeToString(r.written.hash.Sum(nil))
+		} else {
+			// The content was not written in order from the start,
+			// the checksum needs to be computed from the saved chunks
+			r.meta.Checksum, err = r.db.fileChecksum(r.meta)
+			if err != nil {
+				r.txn.Discard()
+				return err
+			}
+		}
+		err = r.db.putFileMeta(r.meta)
 	}
 	r.txn.Discard()
 	return
 }
 
+func newFileVerifier() *fileVerifier {
+	checksum, _ := blake2b.New256(nil)
+	return &fileVerifier{
+		hash: checksum,
+	}
+}
+
+// write adds the content read at the given position to the hash
+func (v *fileVerifier) write(position int64, content []byte) {
+	if v.broken {
+		return
+	}
+	if position != v.position {
+		v.broken = true
+		return
+	}
+
+	v.hash.Write(content)
+	v.position += int64(len(content))
+}
+
+// verify returns ErrIntegrity if the content does not have the size and the checksum of the metadata
+func (v *fileVerifier) verify(meta *FileMeta) error {
+	if v.position != meta.Size {
+		return ErrIntegrity
+	}
+	if meta.Checksum != "" && hex.EncodeToString(v.hash.Sum(nil)) != meta.Checksum {
+		return ErrIntegrity
+	}
+	return nil
+}
+
+// chunksID returns the id used to build the keys of the chunks
+func (m *FileMeta) chunksID() string {
+	if m.Version == "" {
+		return m.ID
+	}
+	return m.ID + "\x00" + m.Version
+}
+
 func (r *readWriter) GetMeta() *FileMeta {
 	return r.meta
 }
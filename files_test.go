@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/dgraph-io/badger"
@@ -326,3 +327,354 @@ func testWriteFileParts(t *testing.T, fileID string, expected []byte, at int64)
 		t.Fatalf("the returned n is not corrected. Expected %d has %d", n, len(expected))
 	}
 }
+
+type failingReader struct {
+	reader io.Reader
+	left   int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.left <= 0 {
+		return 0, fmt.Errorf("read failure")
+	}
+	if len(p) > r.left {
+		p = p[:r.left]
+	}
+	n, err := r.reader.Read(p)
+	r.left -= n
+	return n, err
+}
+
+func TestPutFileAtomic(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	// Change the file size from 5MB to 100KB
+	defaultFileChuckSize := fileChuckSize
+	fileChuckSize = 100 * 1000
+	defer func(defaultFileChuckSize int) {
+		fileChuckSize = defaultFileChuckSize
+	}(defaultFileChuckSize)
+
+	countKeys := func(prefix []byte) (n int) {
+		testDB.badger.View(func(txn *badger.Txn) error {
+			opt := badger.DefaultIteratorOptions
+			opt.PrefetchValues = false
+			it := txn.NewIterator(opt)
+			defer it.Close()
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				n++
+			}
+			return nil
+		})
+		return
+	}
+
+	checkContent := func(fileID string, expected []byte) {
+		readBuff := bytes.NewBuffer(nil)
+		err := testDB.ReadFile(fileID, readBuff)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !bytes.Equal(readBuff.Bytes(), expected) {
+			t.Errorf("the content is not the expected one, it has %d bytes instead of %d", readBuff.Len(), len(expected))
+		}
+	}
+
+	fileID := "atomic file"
+	content := make([]byte, 550*1000)
+	rand.Read(content)
+
+	_, err = testDB.PutFile(fileID, "", bytes.NewBuffer(content))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The write fails in the middle and the previous content is still there
+	newContent := make([]byte, 1000*1000)
+	rand.Read(newContent)
+	_, err = testDB.PutFile(fileID, "", &failingReader{reader: bytes.NewBuffer(newContent), left: 350 * 1000})
+	if err == nil {
+		t.Errorf("the write must fail")
+		return
+	}
+	checkContent(fileID, content)
+
+	// Only the metadata and the 6 chunks of the file are saved
+	if n := countKeys([]byte{prefixFiles}); n != 7 {
+		t.Errorf("expected 7 keys but got %d", n)
+		return
+	}
+
+	_, err = testDB.PutFile(fileID, "", bytes.NewBuffer(newContent))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkContent(fileID, newContent)
+	if n := countKeys([]byte{prefixFiles}); n != 11 {
+		t.Errorf("expected 11 keys but got %d", n)
+		return
+	}
+
+	// The concurrent writes of the same file don't leave the chunks of the replaced versions
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := testDB.PutFile(fileID, "", bytes.NewBuffer(newContent))
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	checkContent(fileID, newContent)
+	if n := countKeys([]byte{prefixFiles}); n != 11 {
+		t.Errorf("expected 11 keys after the concurrent writes but got %d", n)
+		return
+	}
+
+	// Chunks left by a stopped write are removed at the next opening
+	err = testDB.writeFileChunk("abandoned\x00staging", 1, []byte("chunk"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if n := countKeys(testDB.buildFilePrefix("abandoned\x00staging", -1)); n != 1 {
+		t.Errorf("expected the abandoned chunk but got %d keys", n)
+		return
+	}
+
+	testDB.Close()
+	testDB, err = Open(testPath, testConfigKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n := countKeys(testDB.buildFilePrefix("abandoned\x00staging", -1)); n != 0 {
+		t.Errorf("expected the abandoned chunk to be removed but got %d keys", n)
+		return
+	}
+	checkContent(fileID, newContent)
+
+	// An unreadable metadata does not prevent the opening and the chunks are kept
+	// because the ones of this file are not known
+	err = testDB.badger.Update(func(txn *badger.Txn) error {
+		return txn.Set(testDB.buildFilePrefix("unreadable", 0), []byte("not encrypted"))
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = testDB.writeFileChunk("abandoned\x00staging", 1, []byte("chunk"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	testDB.Close()
+	testDB, err = Open(testPath, testConfigKey)
+	if err != nil {
+		t.Errorf("the database must open with an unreadable metadata but got %v", err)
+		return
+	}
+
+	if n := countKeys(testDB.buildFilePrefix("abandoned\x00staging", -1)); n != 1 {
+		t.Errorf("expected the chunk to be kept but got %d keys", n)
+		return
+	}
+	checkContent(fileID, newContent)
+}
+
+func TestFileChecksum(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	// Change the file size from 5MB to 100KB
+	defaultFileChuckSize := fileChuckSize
+	fileChuckSize = 100 * 1000
+	defer func(defaultFileChuckSize int) {
+		fileChuckSize = defaultFileChuckSize
+	}(defaultFileChuckSize)
+
+	fileID := "checksum file"
+	content := make([]byte, 550*1000)
+	rand.Read(content)
+
+	_, err = testDB.PutFile(fileID, "", bytes.NewBuffer(content))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var meta *FileMeta
+	meta, err = testDB.getFileMeta(fileID, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectedChecksum := blake2b.Sum256(content)
+	if meta.Checksum != fmt.Sprintf("%x", expectedChecksum) {
+		t.Errorf("expected the checksum %x but got %q", expectedChecksum, meta.Checksum)
+		return
+	}
+
+	err = testDB.VerifyFile(fileID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err = testDB.VerifyFile("does not exist"); err != ErrNotFound {
+		t.Errorf("expected %v but got %v", ErrNotFound, err)
+		return
+	}
+
+	readAll := func(options *FileReaderOptions) ([]byte, error) {
+		reader, err := testDB.GetFileReaderWithOptions(fileID, options)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		buff := bytes.NewBuffer(nil)
+		_, err = io.Copy(buff, reader)
+		return buff.Bytes(), err
+	}
+
+	var readContent []byte
+	readContent, err = readAll(&FileReaderOptions{VerifyChecksum: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(readContent, content) {
+		t.Errorf("the content is not the expected one")
+		return
+	}
+
+	// The checksum is updated by the writer
+	var writer Writer
+	writer, err = testDB.GetFileWriter(fileID, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = writer.WriteAt([]byte("new content"), 1000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	copy(content[1000:], "new content")
+
+	meta, err = testDB.getFileMeta(fileID, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectedChecksum = blake2b.Sum256(content)
+	if meta.Checksum != fmt.Sprintf("%x", expectedChecksum) {
+		t.Errorf("expected the checksum %x but got %q", expectedChecksum, meta.Checksum)
+		return
+	}
+	if meta.Size != int64(len(content)) {
+		t.Errorf("expected the size %d but got %d", len(content), meta.Size)
+		return
+	}
+	err = testDB.VerifyFile(fileID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The checksum of a new file is computed while it's written
+	streamedID := "streamed file"
+	writer, err = testDB.GetFileWriter(streamedID, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < len(content); i += 150 * 1000 {
+		end := i + 150*1000
+		if end > len(content) {
+			end = len(content)
+		}
+		_, err = writer.Write(content[i:end])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// The checksum is not valid until the writer is closed
+		if writer.GetMeta().Checksum != "" {
+			t.Errorf("the checksum must be empty while writing")
+			return
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if writer.GetMeta().Checksum != meta.Checksum || writer.GetMeta().Size != int64(len(content)) {
+		t.Errorf("expected the checksum %q and the size %d but got %q and %d", meta.Checksum, len(content), writer.GetMeta().Checksum, writer.GetMeta().Size)
+		return
+	}
+	err = testDB.VerifyFile(streamedID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// A modified chunk is detected
+	err = testDB.writeFileChunk(meta.chunksID(), 2, make([]byte, fileChuckSize))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = testDB.VerifyFile(fileID); err != ErrIntegrity {
+		t.Errorf("expected %v but got %v", ErrIntegrity, err)
+		return
+	}
+	if _, err = readAll(&FileReaderOptions{VerifyChecksum: true}); err != ErrIntegrity {
+		t.Errorf("expected %v but got %v", ErrIntegrity, err)
+		return
+	}
+	// The content is given without the option
+	if _, err = readAll(nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// A missing chunk is detected
+	err = testDB.badger.Update(func(txn *badger.Txn) error {
+		return txn.Delete(testDB.buildFilePrefix(meta.chunksID(), 3))
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = testDB.VerifyFile(fileID); err != ErrIntegrity {
+		t.Errorf("expected %v but got %v", ErrIntegrity, err)
+		return
+	}
+	if err = testDB.ReadFile(fileID, bytes.NewBuffer(nil)); err != ErrIntegrity {
+		t.Errorf("expected %v but got %v", ErrIntegrity, err)
+		return
+	}
+}
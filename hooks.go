@@ -0,0 +1,120 @@
+package gotinydb
+
+import (
+	"sync"
+
+	"github.com/alexandrestein/gotinydb/transaction"
+)
+
+type (
+	// BeforePutHook is called when a put operation is added to a batch.
+	// It returns the content which will be saved. This gives the ability to transform
+	// the document or to add derived fields. If an error is returned the operation
+	// is rejected and the error is returned to the caller.
+	BeforePutHook func(id string, content interface{}) (interface{}, error)
+
+	// AfterPutHook is called once the put operation is committed and indexed.
+	AfterPutHook func(id string, content interface{})
+
+	// BeforeDeleteHook is called when a delete operation is added to a batch.
+	// If an error is returned the operation is rejected and the error is returned to the caller.
+	BeforeDeleteHook func(id string) error
+
+	// AfterDeleteHook is called once the delete operation is committed.
+	AfterDeleteHook func(id string)
+
+	// hooks are registered while the writes run. The lock protects the lists and the runs
+	// use the lists as they are when they start, so a hook can register other hooks.
+	hooks struct {
+		lock sync.RWMutex
+
+		beforePut    []BeforePutHook
+		afterPut     []AfterPutHook
+		beforeDelete []BeforeDeleteHook
+		afterDelete  []AfterDeleteHook
+	}
+)
+
+// OnBeforePut registers a function which is called for every put before the
+// document reaches the write routine. Hooks are called in the registration order
+// and every hook receives the content returned by the previous one.
+func (c *Collection) OnBeforePut(fn BeforePutHook) {
+	c.hooks.lock.Lock()
+	defer c.hooks.lock.Unlock()
+
+	c.hooks.beforePut = append(c.hooks.beforePut, fn)
+}
+
+// OnAfterPut registers a function which is called after every successful put
+func (c *Collection) OnAfterPut(fn AfterPutHook) {
+	c.hooks.lock.Lock()
+	defer c.hooks.lock.Unlock()
+
+	c.hooks.afterPut = append(c.hooks.afterPut, fn)
+}
+
+// OnBeforeDelete registers a function which is called for every delete before
+// the operation reaches the write routine.
+func (c *Collection) OnBeforeDelete(fn BeforeDeleteHook) {
+	c.hooks.lock.Lock()
+	defer c.hooks.lock.Unlock()
+
+	c.hooks.beforeDelete = append(c.hooks.beforeDelete, fn)
+}
+
+// OnAfterDelete registers a function which is called after every successful delete
+func (c *Collection) OnAfterDelete(fn AfterDeleteHook) {
+	c.hooks.lock.Lock()
+	defer c.hooks.lock.Unlock()
+
+	c.hooks.afterDelete = append(c.hooks.afterDelete, fn)
+}
+
+// runBeforePut runs the before put hooks and returns the content to save
+func (h *hooks) runBeforePut(id string, content interface{}) (_ interface{}, err error) {
+	h.lock.RLock()
+	beforePut := h.beforePut
+	h.lock.RUnlock()
+
+	for _, fn := range beforePut {
+		content, err = fn(id, content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return content, nil
+}
+
+func (h *hooks) runBeforeDelete(id string) error {
+	h.lock.RLock()
+	beforeDelete := h.beforeDelete
+	h.lock.RUnlock()
+
+	for _, fn := range beforeDelete {
+		err := fn(id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runAfter calls the after hooks for every operations of the committed transaction
+func (h *hooks) runAfter(tr *transaction.Transaction) {
+	h.lock.RLock()
+	afterPut, afterDelete := h.afterPut, h.afterDelete
+	h.lock.RUnlock()
+
+	for _, op := range tr.Operations {
+		if op.Delete {
+			for _, fn := range afterDelete {
+				fn(op.CollectionID)
+			}
+			continue
+		}
+
+		for _, fn := range afterPut {
+			fn(op.CollectionID, op.Content)
+		}
+	}
+}
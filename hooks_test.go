@@ -0,0 +1,155 @@
+package gotinydb
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	errRejected := fmt.Errorf("rejected")
+
+	testCol.OnBeforePut(func(id string, content interface{}) (interface{}, error) {
+		user, ok := content.(*testUserStruct)
+		if !ok {
+			return content, nil
+		}
+		if user.Email == "" {
+			return nil, errRejected
+		}
+
+		// Derived field
+		ret := *user
+		ret.Name = user.Name + " checked"
+		return &ret, nil
+	})
+
+	putCalled := []string{}
+	testCol.OnAfterPut(func(id string, content interface{}) {
+		putCalled = append(putCalled, id)
+	})
+
+	testCol.OnBeforeDelete(func(id string) error {
+		if id == testUserID {
+			return errRejected
+		}
+		return nil
+	})
+
+	deleteCalled := []string{}
+	testCol.OnAfterDelete(func(id string) {
+		deleteCalled = append(deleteCalled, id)
+	})
+
+	err = testCol.Put("rejected", &testUserStruct{Name: "no email"})
+	if err != errRejected {
+		t.Errorf("the put must be rejected but got %v", err)
+		return
+	}
+
+	_, err = testCol.Get("rejected", nil)
+	if err == nil {
+		t.Errorf("the rejected document must not be saved")
+		return
+	}
+
+	err = testCol.Put("hooked", &testUserStruct{Name: "hooked", Email: "hooked@internet.org"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	retrievedUser := new(testUserStruct)
+	_, err = testCol.Get("hooked", retrievedUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if retrievedUser.Name != "hooked checked" {
+		t.Errorf("the before put hook must transform the document but got %q", retrievedUser.Name)
+		return
+	}
+
+	if !reflect.DeepEqual(putCalled, []string{"hooked"}) {
+		t.Errorf("the after put hook must be called once but got %v", putCalled)
+		return
+	}
+
+	err = testCol.Delete(testUserID)
+	if err != errRejected {
+		t.Errorf("the delete must be rejected but got %v", err)
+		return
+	}
+
+	err = testCol.Delete("hooked")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !reflect.DeepEqual(deleteCalled, []string{"hooked"}) {
+		t.Errorf("the after delete hook must be called once but got %v", deleteCalled)
+		return
+	}
+}
+
+func TestHooksConcurrentRegistration(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	// The hooks are registered while the writes run
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 50; i++ {
+			err := testCol.Put(fmt.Sprintf("concurrent %d", i), testUser)
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	called := map[string]int{}
+	lock := sync.Mutex{}
+	for i := 0; i < 50; i++ {
+		testCol.OnBeforePut(func(id string, content interface{}) (interface{}, error) {
+			return content, nil
+		})
+		testCol.OnAfterPut(func(id string, content interface{}) {
+			lock.Lock()
+			called[id]++
+			lock.Unlock()
+		})
+	}
+
+	err = <-done
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// All the hooks are called by the next writes
+	err = testCol.Put("after registration", testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if called["after registration"] != 50 {
+		t.Errorf("expected the 50 after put hooks to be called but got %d", called["after registration"])
+		return
+	}
+}
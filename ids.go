@@ -0,0 +1,139 @@
+package gotinydb
+
+import (
+	"crypto/rand"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/oklog/ulid"
+)
+
+// sequenceBandwidth is the number of values leased at once by the sequences
+const sequenceBandwidth = 1000
+
+type (
+	// Sequence gives persistent and monotonic numbers starting at 0. It's returned by *DB.Sequence.
+	// The numbers are leased by ranges to not write on every call, the numbers not used
+	// of the range are lost if the database is not closed properly.
+	// The sequences are saved by Badger directly, their names and their values are not encrypted.
+	Sequence struct {
+		name string
+		seq  *badger.Sequence
+	}
+
+	// sequenceStore keeps the sequences in use by name
+	sequenceStore struct {
+		lock      sync.Mutex
+		sequences map[string]*Sequence
+	}
+
+	// idGenerator makes the ULIDs of a process monotonic even inside the same millisecond
+	// and when the clock goes back
+	idGenerator struct {
+		lock    sync.Mutex
+		entropy io.Reader
+		// last is the timestamp of the last id
+		last uint64
+	}
+)
+
+var ids = &idGenerator{
+	entropy: ulid.Monotonic(rand.Reader, 0),
+}
+
+// NewID returns a new ULID. The ids are sorted by creation time and the ids
+// made by the same process are always increasing.
+// An error is returned if too many ids are made in the same millisecond.
+func NewID() (string, error) {
+	return ids.new(time.Now())
+}
+
+func (g *idGenerator) new(t time.Time) (string, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	// The ids keep increasing if the clock goes back
+	timestamp := ulid.Timestamp(t)
+	if timestamp < g.last {
+		timestamp = g.last
+	}
+
+	id, err := ulid.New(timestamp, g.entropy)
+	if err != nil {
+		return "", err
+	}
+	g.last = timestamp
+
+	return id.String(), nil
+}
+
+// Insert saves the content with a new id and returns the id.
+// The ids are ULIDs ordered by creation time so *Collection.GetRevertedIterator
+// gives the last inserted documents first.
+func (c *Collection) Insert(content interface{}) (id string, err error) {
+	id, err = NewID()
+	if err != nil {
+		return "", err
+	}
+
+	err = c.Put(id, content)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// Sequence returns the sequence with the given name. It's created if it does not exist.
+// The sequences are released when the database is closed.
+func (d *DB) Sequence(name string) (*Sequence, error) {
+	d.sequences.lock.Lock()
+	defer d.sequences.lock.Unlock()
+
+	if seq, found := d.sequences.sequences[name]; found {
+		return seq, nil
+	}
+
+	key := append([]byte{prefixSequences}, name...)
+	badgerSeq, err := d.badger.GetSequence(key, sequenceBandwidth)
+	if err != nil {
+		return nil, err
+	}
+
+	seq := &Sequence{
+		name: name,
+		seq:  badgerSeq,
+	}
+	d.sequences.sequences[name] = seq
+
+	return seq, nil
+}
+
+// releaseSequences gives back the leased numbers which are not used.
+// All sequences are released and the first error is returned.
+func (d *DB) releaseSequences() (err error) {
+	d.sequences.lock.Lock()
+	defer d.sequences.lock.Unlock()
+
+	for name, seq := range d.sequences.sequences {
+		releaseErr := seq.seq.Release()
+		if releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+		delete(d.sequences.sequences, name)
+	}
+
+	return
+}
+
+// Name returns the name of the sequence
+func (s *Sequence) Name() string {
+	return s.name
+}
+
+// Next returns the next number of the sequence
+func (s *Sequence) Next() (uint64, error) {
+	return s.seq.Next()
+}
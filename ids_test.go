@@ -0,0 +1,153 @@
+package gotinydb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInsert(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	var col *Collection
+	col, err = testDB.Use("insert")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	insertedIDs := []string{}
+	for i := 0; i < 100; i++ {
+		var id string
+		id, err = col.Insert(&testUserStruct{Name: "user"})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(insertedIDs) != 0 && id <= insertedIDs[len(insertedIDs)-1] {
+			t.Errorf("the id %q must be bigger than %q", id, insertedIDs[len(insertedIDs)-1])
+			return
+		}
+		insertedIDs = append(insertedIDs, id)
+	}
+
+	_, err = col.Get(insertedIDs[50], nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The last inserted document is the first of the reverted iterator
+	iter := col.GetRevertedIterator()
+	defer iter.Close()
+	if !iter.Valid() || iter.GetID() != insertedIDs[len(insertedIDs)-1] {
+		t.Errorf("expected %q but got %q", insertedIDs[len(insertedIDs)-1], iter.GetID())
+		return
+	}
+
+	var newID string
+	newID, err = NewID()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if newID <= insertedIDs[len(insertedIDs)-1] {
+		t.Errorf("an id made later must be bigger")
+		return
+	}
+
+	// The ids keep increasing if the clock goes back
+	var pastID string
+	pastID, err = ids.new(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pastID <= newID {
+		t.Errorf("the id %q must be bigger than %q", pastID, newID)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	var seq *Sequence
+	seq, err = testDB.Sequence("orders")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var last uint64
+	for i := 0; i < 10; i++ {
+		var n uint64
+		n, err = seq.Next()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if i != 0 && n <= last {
+			t.Errorf("expected a number bigger than %d but got %d", last, n)
+			return
+		}
+		last = n
+	}
+
+	// The same sequence is given back
+	var sameSeq *Sequence
+	sameSeq, err = testDB.Sequence("orders")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if sameSeq != seq {
+		t.Errorf("the sequence must be shared")
+		return
+	}
+
+	// The sequence continues after the opening
+	testDB.Close()
+	testDB, err = Open(testPath, testConfigKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	seq, err = testDB.Sequence("orders")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var n uint64
+	n, err = seq.Next()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if n <= last {
+		t.Errorf("expected a number bigger than %d but got %d", last, n)
+		return
+	}
+
+	// An other sequence starts at 0
+	seq, err = testDB.Sequence("invoices")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	n, err = seq.Next()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if n != 0 {
+		t.Errorf("expected 0 but got %d", n)
+	}
+}
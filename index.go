@@ -4,12 +4,20 @@ import (
 	"archive/zip"
 	"bytes"
 	"compress/flate"
+	"context"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/alexandrestein/gotinydb/transaction"
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/dgraph-io/badger"
+	"golang.org/x/crypto/blake2b"
 )
 
 type (
@@ -21,9 +29,30 @@ type (
 
 		bleveIndex bleve.Index
 		Path       string
+		// Async is public for marshalling reason and should never be used directly.
+		// Use *Collection.SetIndexAsync to change it.
+		Async bool
 
 		BleveIndexAsBytes []byte
 	}
+
+	// buildingIndex is an index built in the background to replace an existing one.
+	// The documents written during the build are marked and indexed again before the swap.
+	buildingIndex struct {
+		index *BleveIndex
+
+		lock    sync.Mutex
+		dirty   map[string]bool
+		swapped bool
+	}
+
+	// IndexVerification is returned by *Collection.VerifyIndex
+	IndexVerification struct {
+		// Missing lists the ids of the documents which are not in the index
+		Missing []string
+		// Stale lists the ids which are in the index but not in the collection
+		Stale []string
+	}
 )
 
 func newIndex(name string) *BleveIndex {
@@ -34,6 +63,27 @@ func newIndex(name string) *BleveIndex {
 	}
 }
 
+// indexOperations updates the index with the operations of the transaction
+func (i *BleveIndex) indexOperations(tr *transaction.Transaction) error {
+	for _, op := range tr.Operations {
+		// If remove the content it needs to be removed from the index
+		if op.Delete {
+			err := i.bleveIndex.Delete(op.CollectionID)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := i.bleveIndex.Index(op.CollectionID, op.Content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (i *BleveIndex) close() error {
 	return i.bleveIndex.Close()
 }
@@ -149,3 +199,351 @@ func (i *BleveIndex) indexUnzipper() error {
 
 	return nil
 }
+
+// Ok returns true if the index and the collection are in sync
+func (v *IndexVerification) Ok() bool {
+	return len(v.Missing) == 0 && len(v.Stale) == 0
+}
+
+// RebuildIndex re-creates the index from the documents of the collection with the same mapping.
+// The new index is built under a new prefix while the old one keeps serving the searches,
+// it replaces the old one once all documents are indexed.
+// The progress function is called after every indexed document if not nil.
+// If it returns an error the old index is kept.
+// The builds of a same index are done one after the other.
+func (c *Collection) RebuildIndex(ctx context.Context, name string, progress func(done, total int)) error {
+	unlock := c.lockIndexBuild(name)
+	defer unlock()
+
+	// The index is read once locked because the previous build can replace it
+	index, err := c.GetBleveIndex(name)
+	if err != nil {
+		return err
+	}
+
+	building, err := c.startBuildingIndex(index, index.bleveIndex.Mapping())
+	if err != nil {
+		return err
+	}
+
+	return c.buildAndSwapIndex(ctx, building, index, progress)
+}
+
+// UpdateBleveIndexMapping replaces the mapping of an existing index.
+// The new index is built in the background under a temporary prefix while the old one
+// keeps serving the searches. Once all documents are indexed the new index replaces the
+// old one and the configuration is saved.
+// The returned channel gives the result of the build once it's done.
+// The builds of a same index are done one after the other.
+func (c *Collection) UpdateBleveIndexMapping(name string, newMapping mapping.IndexMapping) (<-chan error, error) {
+	_, err := c.GetBleveIndex(name)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		unlock := c.lockIndexBuild(name)
+		defer unlock()
+
+		// The index is read once locked because the previous build can replace it
+		oldIndex, err := c.GetBleveIndex(name)
+		if err != nil {
+			done <- err
+			return
+		}
+
+		building, err := c.startBuildingIndex(oldIndex, newMapping)
+		if err != nil {
+			done <- err
+			return
+		}
+
+		done <- c.buildAndSwapIndex(c.db.ctx, building, oldIndex, nil)
+	}()
+
+	return done, nil
+}
+
+// lockIndexBuild serializes the builds of the index with the given name, the old index
+// is closed by the build which replaces it. It returns the function which unlocks.
+func (c *Collection) lockIndexBuild(name string) (unlock func()) {
+	c.buildingIndexesLock.Lock()
+	if c.indexBuildLocks == nil {
+		c.indexBuildLocks = map[string]*sync.Mutex{}
+	}
+	lock, found := c.indexBuildLocks[name]
+	if !found {
+		lock = new(sync.Mutex)
+		c.indexBuildLocks[name] = lock
+	}
+	c.buildingIndexesLock.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// startBuildingIndex makes a new index to replace the old one with a new prefix.
+// The writes are marked from now to be indexed again before the swap.
+func (c *Collection) startBuildingIndex(oldIndex *BleveIndex, newMapping mapping.IndexMapping) (*buildingIndex, error) {
+	// The prefix of the new index must be different from the prefix of the old one
+	var index *BleveIndex
+	var err error
+	for try := 0; try < 10; try++ {
+		indexHash := blake2b.Sum256([]byte(oldIndex.Name + strconv.FormatInt(time.Now().UnixNano(), 10)))
+		index, err = c.buildBleveIndex(oldIndex.Name, indexHash[:2], newMapping)
+		if err != ErrHashCollision {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	index.Async = oldIndex.Async
+
+	building := &buildingIndex{
+		index: index,
+		dirty: map[string]bool{},
+	}
+	c.buildingIndexesLock.Lock()
+	c.buildingIndexes = append(c.buildingIndexes, building)
+	c.buildingIndexesLock.Unlock()
+
+	return building, nil
+}
+
+// buildAndSwapIndex indexes the documents in the new index and replaces the old one with it.
+// If an error occurs the new index is removed and the old one is kept.
+func (c *Collection) buildAndSwapIndex(ctx context.Context, building *buildingIndex, oldIndex *BleveIndex, progress func(done, total int)) error {
+	index := building.index
+	defer c.removeBuildingIndex(building)
+
+	fail := func(err error) error {
+		index.close()
+		index.delete()
+		c.db.deletePrefix(index.Prefix)
+		return err
+	}
+
+	err := c.indexExistingDocuments(ctx, index, progress)
+	if err != nil {
+		return fail(err)
+	}
+
+	// Index again the documents written during the build.
+	// The lock is kept for the last round to make the swap atomic with the writes.
+	building.lock.Lock()
+	for len(building.dirty) > 100 {
+		dirty := building.dirty
+		building.dirty = map[string]bool{}
+		building.lock.Unlock()
+
+		err = c.reindexIDs(index, dirty)
+		if err != nil {
+			return fail(err)
+		}
+
+		building.lock.Lock()
+	}
+
+	err = c.reindexIDs(index, building.dirty)
+	if err != nil {
+		building.lock.Unlock()
+		return fail(err)
+	}
+
+	// Swap the indexes, the writes done from now update the new index with the others
+	c.replaceBleveIndex(oldIndex, index)
+	building.swapped = true
+	building.lock.Unlock()
+
+	err = c.db.saveConfig()
+	if err != nil {
+		return err
+	}
+
+	oldIndex.close()
+	oldIndex.delete()
+	c.db.deletePrefix(oldIndex.Prefix)
+
+	return nil
+}
+
+func (c *Collection) reindexIDs(index *BleveIndex, ids map[string]bool) error {
+	for id := range ids {
+		err := c.reindexDocument([]*BleveIndex{index}, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Collection) removeBuildingIndex(building *buildingIndex) {
+	c.buildingIndexesLock.Lock()
+	defer c.buildingIndexesLock.Unlock()
+
+	for i, tmpBuilding := range c.buildingIndexes {
+		if tmpBuilding == building {
+			c.buildingIndexes = append(c.buildingIndexes[:i], c.buildingIndexes[i+1:]...)
+			return
+		}
+	}
+}
+
+// markForBuildingIndexes saves the ids written during the build of the indexes.
+// If the index is already swapped it's updated with the other indexes of the collection.
+func (c *Collection) markForBuildingIndexes(tr *transaction.Transaction) {
+	c.buildingIndexesLock.Lock()
+	buildings := make([]*buildingIndex, len(c.buildingIndexes))
+	copy(buildings, c.buildingIndexes)
+	c.buildingIndexesLock.Unlock()
+
+	for _, building := range buildings {
+		building.lock.Lock()
+		if !building.swapped {
+			for _, op := range tr.Operations {
+				building.dirty[op.CollectionID] = true
+			}
+		}
+		building.lock.Unlock()
+	}
+}
+
+// VerifyIndex compares the ids in the index with the ids of the collection
+func (c *Collection) VerifyIndex(name string) (*IndexVerification, error) {
+	index, err := c.GetBleveIndex(name)
+	if err != nil {
+		return nil, err
+	}
+
+	indexedIDs, err := index.docIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := new(IndexVerification)
+	err = c.db.badger.View(func(txn *badger.Txn) error {
+		opt := badger.DefaultIteratorOptions
+		opt.PrefetchValues = false
+		iter := txn.NewIterator(opt)
+		defer iter.Close()
+
+		colPrefix := c.buildDBKey("")
+		for iter.Seek(colPrefix); iter.ValidForPrefix(colPrefix); iter.Next() {
+			id := string(iter.Item().Key()[len(colPrefix):])
+			if indexedIDs[id] {
+				delete(indexedIDs, id)
+				continue
+			}
+
+			ret.Missing = append(ret.Missing, id)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for id := range indexedIDs {
+		ret.Stale = append(ret.Stale, id)
+	}
+
+	return ret, nil
+}
+
+// docIDs returns the ids of all documents of the index. They are read from the index
+// directly without search.
+func (i *BleveIndex) docIDs() (map[string]bool, error) {
+	advancedIndex, _, err := i.bleveIndex.Advanced()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := advancedIndex.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	docIDReader, err := reader.DocIDReaderAll()
+	if err != nil {
+		return nil, err
+	}
+	defer docIDReader.Close()
+
+	ret := map[string]bool{}
+	for {
+		internalID, err := docIDReader.Next()
+		if err != nil {
+			return nil, err
+		}
+		// The end of the index
+		if internalID == nil {
+			return ret, nil
+		}
+
+		id, err := reader.ExternalID(internalID)
+		if err != nil {
+			return nil, err
+		}
+		ret[id] = true
+	}
+}
+
+// indexExistingDocuments adds all documents of the collection to the given index
+func (c *Collection) indexExistingDocuments(ctx context.Context, index *BleveIndex, progress func(done, total int)) error {
+	colPrefix := c.buildDBKey("")
+
+	total := 0
+	if progress != nil {
+		c.db.badger.View(func(txn *badger.Txn) error {
+			opt := badger.DefaultIteratorOptions
+			opt.PrefetchValues = false
+			iter := txn.NewIterator(opt)
+			defer iter.Close()
+
+			for iter.Seek(colPrefix); iter.ValidForPrefix(colPrefix); iter.Next() {
+				total++
+			}
+			return nil
+		})
+	}
+
+	return c.db.badger.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		done := 0
+		for iter.Seek(colPrefix); iter.ValidForPrefix(colPrefix); iter.Next() {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+
+			item := iter.Item()
+
+			clearBytes, err := c.db.decryptAndDecompressItem(item)
+			if err != nil {
+				continue
+			}
+
+			id := string(item.Key()[len(colPrefix):])
+
+			content := c.fromValueBytesGetContentToIndex(clearBytes)
+			err = index.bleveIndex.Index(id, content)
+			if err != nil {
+				return err
+			}
+
+			done++
+			if progress != nil {
+				progress(done, total)
+			}
+		}
+
+		return nil
+	})
+}
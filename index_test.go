@@ -1,10 +1,13 @@
 package gotinydb
 
 import (
+	"context"
+	"fmt"
 	"reflect"
 	"testing"
 
 	"github.com/blevesearch/bleve"
+	"github.com/dgraph-io/badger"
 )
 
 func TestIndexExistingValue(t *testing.T) {
@@ -187,3 +190,486 @@ func TestIndexResultNext(t *testing.T) {
 		return
 	}
 }
+
+func TestIndexJournalReplay(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	crashedUserID := "crashed user"
+	crashedUser := &testUserStruct{"lost", "lost@internet.org", nil}
+
+	// Simulate a stop after the commit and before the indexes are updated
+	b, _ := testCol.NewBatch(context.Background())
+	err = b.Put(crashedUserID, crashedUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	b.tr.Operations = append(b.tr.Operations, testCol.buildIndexJournalOperations(b.tr.Operations)...)
+	err = testCol.putSendToWriteAndWaitForResponse(b.tr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	query := bleve.NewQueryStringQuery(crashedUser.Email)
+	_, err = testCol.Search(testIndexName, query)
+	if err == nil {
+		t.Errorf("the document must not be indexed before the replay")
+		return
+	}
+
+	testDB.Close()
+	testDB, err = Open(testPath, testConfigKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	testCol, err = testDB.Use(testColName)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var searchResult *SearchResult
+	searchResult, err = testCol.Search(testIndexName, query)
+	if err != nil {
+		t.Errorf("the document must be indexed after the replay: %s", err.Error())
+		return
+	}
+	if searchResult.BleveSearchResult.Hits[0].ID != crashedUserID {
+		t.Errorf("expected %q but got %q", crashedUserID, searchResult.BleveSearchResult.Hits[0].ID)
+		return
+	}
+
+	prefix := testCol.buildIndexJournalPrefix()
+	testDB.badger.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		iter.Seek(prefix)
+		if iter.ValidForPrefix(prefix) {
+			t.Errorf("the journal must be empty after the replay")
+		}
+		return nil
+	})
+}
+
+func TestRebuildAndVerifyIndex(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	var verification *IndexVerification
+	verification, err = testCol.VerifyIndex("all")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !verification.Ok() {
+		t.Errorf("the index must be in sync but got %v", verification)
+		return
+	}
+
+	// Make the index drift from the collection
+	index, _ := testCol.GetBleveIndex("all")
+	index.bleveIndex.Delete(testUserID)
+	index.bleveIndex.Index("ghost", map[string]interface{}{"name": "ghost"})
+
+	verification, err = testCol.VerifyIndex("all")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(verification.Missing, []string{testUserID}) {
+		t.Errorf("expected missing %v but got %v", []string{testUserID}, verification.Missing)
+		return
+	}
+	if !reflect.DeepEqual(verification.Stale, []string{"ghost"}) {
+		t.Errorf("expected stale %v but got %v", []string{"ghost"}, verification.Stale)
+		return
+	}
+
+	// The old index keeps serving the searches during the rebuild
+	lastDone, lastTotal := 0, 0
+	var searchErr error
+	err = testCol.RebuildIndex(context.Background(), "all", func(done, total int) {
+		lastDone, lastTotal = done, total
+		_, searchErr = testCol.Search("all", bleve.NewMatchAllQuery())
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if lastDone != 2 || lastTotal != 2 {
+		t.Errorf("expected progress 2/2 but got %d/%d", lastDone, lastTotal)
+		return
+	}
+	if searchErr != nil {
+		t.Errorf("the index must be searchable during the rebuild: %v", searchErr)
+		return
+	}
+
+	verification, err = testCol.VerifyIndex("all")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !verification.Ok() {
+		t.Errorf("the index must be in sync after the rebuild but got %v", verification)
+		return
+	}
+
+	// A cancelled rebuild keeps the old index
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = testCol.RebuildIndex(ctx, "all", nil)
+	if err != context.Canceled {
+		t.Errorf("expected %v but got %v", context.Canceled, err)
+		return
+	}
+	verification, err = testCol.VerifyIndex("all")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !verification.Ok() {
+		t.Errorf("the old index must be kept but got %v", verification)
+		return
+	}
+
+	// The concurrent rebuilds of the same index are done one after the other
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- testCol.RebuildIndex(context.Background(), "all", nil)
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err = <-errs; err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	verification, err = testCol.VerifyIndex("all")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !verification.Ok() {
+		t.Errorf("the index must be in sync after the concurrent rebuilds but got %v", verification)
+		return
+	}
+
+	_, err = testCol.VerifyIndex("does not exist")
+	if err != ErrIndexNotFound {
+		t.Errorf("expected %v but got %v", ErrIndexNotFound, err)
+		return
+	}
+}
+
+func TestUpdateBleveIndexMapping(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	// The email index does not index the names
+	query := bleve.NewQueryStringQuery(testUser.Name)
+	_, err = testCol.Search(testIndexName, query)
+	if err == nil {
+		t.Errorf("the name must not be indexed")
+		return
+	}
+
+	oldIndex, _ := testCol.GetBleveIndex(testIndexName)
+
+	var done <-chan error
+	done, err = testCol.UpdateBleveIndexMapping(testIndexName, bleve.NewIndexMapping())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// The old index serves the searches until the swap
+	_, err = testCol.GetBleveIndex(testIndexName)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = <-done
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	newIndex, _ := testCol.GetBleveIndex(testIndexName)
+	if reflect.DeepEqual(oldIndex.Prefix, newIndex.Prefix) {
+		t.Errorf("the new index must have a new prefix")
+		return
+	}
+
+	_, err = testCol.Search(testIndexName, query)
+	if err != nil {
+		t.Errorf("the name must be indexed with the new mapping: %s", err.Error())
+		return
+	}
+
+	// The new mapping must be used after a restart
+	testDB.Close()
+	testDB, err = Open(testPath, testConfigKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	testCol, err = testDB.Use(testColName)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = testCol.Search(testIndexName, query)
+	if err != nil {
+		t.Errorf("the name must be indexed after a restart: %s", err.Error())
+		return
+	}
+
+	_, err = testCol.UpdateBleveIndexMapping("does not exist", bleve.NewIndexMapping())
+	if err != ErrIndexNotFound {
+		t.Errorf("expected %v but got %v", ErrIndexNotFound, err)
+		return
+	}
+}
+
+func TestSearchIterator(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	b, _ := testCol.NewBatch(context.Background())
+	for i := 0; i < 250; i++ {
+		b.Put(fmt.Sprintf("iterator user %d", i), &testUserStruct{"iterator", fmt.Sprintf("user%d@iterator.org", i), nil})
+	}
+	err = b.Write()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	searchRequest := bleve.NewSearchRequestOptions(bleve.NewMatchQuery("iterator"), 20, 0, false)
+	var iter *SearchIterator
+	iter, err = testCol.SearchIterator("all", searchRequest)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if iter.Total() != 250 {
+		t.Errorf("expected 250 results but got %d", iter.Total())
+		return
+	}
+
+	seen := map[string]bool{}
+	for {
+		user := new(testUserStruct)
+		var id string
+		id, err = iter.Next(user)
+		if err == ErrEndOfQueryResult {
+			break
+		} else if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if user.Name != "iterator" {
+			t.Errorf("the document of %q is not loaded: %v", id, user)
+			return
+		}
+		seen[id] = true
+	}
+	if len(seen) != 250 {
+		t.Errorf("expected 250 different documents but got %d", len(seen))
+		return
+	}
+	if searchRequest.From != 0 {
+		t.Errorf("the request of the caller must not be changed")
+		return
+	}
+
+	// An empty result is not an error
+	iter, err = testCol.SearchIterator("all", bleve.NewSearchRequest(bleve.NewMatchQuery("nothing")))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = iter.Next(nil)
+	if err != ErrEndOfQueryResult {
+		t.Errorf("expected %v but got %v", ErrEndOfQueryResult, err)
+		return
+	}
+}
+
+func TestSearchAcross(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	var otherCol *Collection
+	otherCol, err = testDB.Use("other collection")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = otherCol.SetBleveIndex("all", bleve.NewIndexMapping())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The same id in the two collections with different content
+	err = otherCol.Put(testUserID, &testUserStruct{"toto other", "other@internet.org", nil})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	refs := []IndexRef{
+		{Collection: testColName, Index: "all"},
+		{Collection: "other collection", Index: "all"},
+	}
+	var searchResult *SearchResult
+	searchResult, err = testDB.SearchAcross(refs, bleve.NewSearchRequest(bleve.NewMatchQuery("toto")))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if searchResult.BleveSearchResult.Total != 3 {
+		t.Errorf("expected 3 results but got %d", searchResult.BleveSearchResult.Total)
+		return
+	}
+
+	found := map[string]string{}
+	for {
+		user := new(testUserStruct)
+		var resp *Response
+		resp, err = searchResult.NextResponse(user)
+		if err == ErrEndOfQueryResult {
+			break
+		} else if err != nil {
+			t.Error(err)
+			return
+		}
+		found[resp.Collection+"/"+resp.ID] = user.Name
+	}
+
+	expected := map[string]string{
+		testColName + "/" + testUserID:      testUser.Name,
+		testColName + "/" + cloneTestUserID: cloneTestUser.Name,
+		"other collection/" + testUserID:    "toto other",
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected %v but got %v", expected, found)
+		return
+	}
+
+	// The indexes given twice are searched once
+	searchResult, err = testDB.SearchAcross(append(refs, refs[0]), bleve.NewSearchRequest(bleve.NewMatchQuery("toto")))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if searchResult.BleveSearchResult.Total != 3 {
+		t.Errorf("expected 3 results with the duplicated index but got %d", searchResult.BleveSearchResult.Total)
+		return
+	}
+
+	_, err = testDB.SearchAcross([]IndexRef{{Collection: "does not exist", Index: "all"}}, bleve.NewSearchRequest(bleve.NewMatchQuery("toto")))
+	if err != ErrCollectionNotFound {
+		t.Errorf("expected %v but got %v", ErrCollectionNotFound, err)
+		return
+	}
+
+	_, err = testDB.SearchAcross(refs, bleve.NewSearchRequest(bleve.NewMatchQuery("nobody")))
+	if err != ErrNotFound {
+		t.Errorf("expected %v but got %v", ErrNotFound, err)
+		return
+	}
+
+	// The hits of an unknown index can't be loaded
+	searchResult, err = testDB.SearchAcross(refs, bleve.NewSearchRequest(bleve.NewMatchQuery("toto")))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	searchResult.collections = map[string]*Collection{}
+	_, err = searchResult.Next(nil)
+	if err != ErrCollectionNotFound {
+		t.Errorf("expected %v but got %v", ErrCollectionNotFound, err)
+		return
+	}
+}
+
+func TestSearchString(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	opts := SearchOptions{
+		Sort:      []string{"-_id"},
+		Fields:    []string{"email"},
+		Facets:    map[string]int{"email": 5},
+		Highlight: "html",
+	}
+
+	var searchResult *SearchResult
+	searchResult, err = testCol.SearchString(testIndexName, "email:username", opts)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	hits := searchResult.Hits()
+	if len(hits) != 2 {
+		t.Errorf("expected 2 hits but got %d", len(hits))
+		return
+	}
+	// Reverse order of the ids
+	if hits[0].ID != cloneTestUserID || hits[1].ID != testUserID {
+		t.Errorf("the hits are not sorted: %q %q", hits[0].ID, hits[1].ID)
+		return
+	}
+	if hits[0].Fields["email"] != testUser.Email {
+		t.Errorf("expected the field %q but got %v", testUser.Email, hits[0].Fields["email"])
+		return
+	}
+	if len(hits[0].Fragments["email"]) == 0 {
+		t.Errorf("the email must be highlighted")
+		return
+	}
+
+	facet, ok := searchResult.Facets()["email"]
+	if !ok {
+		t.Errorf("the facet is missing")
+		return
+	}
+	if facet.Field != "email" || len(facet.Terms) == 0 || facet.Terms[0].Count != 2 {
+		t.Errorf("unexpected facet %v", facet)
+		return
+	}
+
+	_, err = testCol.SearchString(testIndexName, "email:nobody", SearchOptions{})
+	if err != ErrNotFound {
+		t.Errorf("expected %v but got %v", ErrNotFound, err)
+		return
+	}
+}
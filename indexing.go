@@ -0,0 +1,239 @@
+package gotinydb
+
+import (
+	"context"
+	"sync"
+
+	"github.com/alexandrestein/gotinydb/transaction"
+)
+
+type (
+	// asyncIndexing holds the queue of the writes waiting to be indexed
+	// by the asynchronous indexes of a collection
+	asyncIndexing struct {
+		// queueLock makes sure the jobs are in the queue in the order of the versions.
+		// The asynchronous indexes of the jobs are chosen with it and the mode of the indexes
+		// is changed with it, this way no write is missed when an index changes of mode.
+		queueLock sync.Mutex
+		// lock protects the versions and the error
+		lock sync.Mutex
+
+		// version is the version of the last write added to the queue
+		version uint64
+		// indexedVersion is the version of the last write indexed
+		indexedVersion uint64
+		// indexedChan is closed and replaced every time indexedVersion changes
+		indexedChan chan struct{}
+		// err saves the last indexing error until it's cleared by *Collection.ClearIndexingError
+		err error
+
+		queue chan *indexingJob
+	}
+
+	indexingJob struct {
+		version uint64
+		tr      *transaction.Transaction
+		journal []*transaction.Operation
+		// indexes are the names of the indexes which were asynchronous when the job was queued.
+		// The names are kept because the indexes can be replaced by *Collection.UpdateBleveIndexMapping.
+		indexes map[string]bool
+	}
+)
+
+// SetIndexAsync defines if the index is updated in the background.
+// Puts and deletes return without waiting for the asynchronous indexes.
+// Use *Collection.WaitIndexed or SearchRequest.WaitIndexed to read your writes.
+func (c *Collection) SetIndexAsync(name string, async bool) error {
+	index, err := c.GetBleveIndex(name)
+	if err != nil {
+		return err
+	}
+
+	if index.Async == async {
+		return nil
+	}
+
+	c.indexing.queueLock.Lock()
+	index.Async = async
+	c.indexing.queueLock.Unlock()
+
+	if !async {
+		// The writes queued before the change are indexed before returning
+		err = c.waitIndexed(context.Background(), 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.db.saveConfig()
+}
+
+// Version returns the version of the last write queued for the asynchronous indexes.
+// It can be given to *Collection.WaitIndexed.
+func (c *Collection) Version() uint64 {
+	c.indexing.lock.Lock()
+	defer c.indexing.lock.Unlock()
+
+	return c.indexing.version
+}
+
+// WaitIndexed blocks until the asynchronous indexes are done with the writes up to the given version.
+// If version is 0 it waits for all writes done before the call.
+// It returns the error of the indexing if any. The error is returned until it's cleared
+// with *Collection.ClearIndexingError, the indexes should be rebuilt before.
+func (c *Collection) WaitIndexed(ctx context.Context, version uint64) error {
+	err := c.waitIndexed(ctx, version)
+	if err != nil {
+		return err
+	}
+
+	c.indexing.lock.Lock()
+	defer c.indexing.lock.Unlock()
+
+	return c.indexing.err
+}
+
+// ClearIndexingError removes the error saved by the asynchronous indexing
+func (c *Collection) ClearIndexingError() {
+	c.indexing.lock.Lock()
+	defer c.indexing.lock.Unlock()
+
+	c.indexing.err = nil
+}
+
+// waitIndexed is like *Collection.WaitIndexed but it does not return the indexing error
+func (c *Collection) waitIndexed(ctx context.Context, version uint64) error {
+	for {
+		c.indexing.lock.Lock()
+		if version == 0 {
+			version = c.indexing.version
+		}
+
+		if c.indexing.indexedVersion >= version {
+			c.indexing.lock.Unlock()
+			return nil
+		}
+
+		waitChan := c.indexing.indexedChan
+		c.indexing.lock.Unlock()
+
+		select {
+		case <-waitChan:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.db.ctx.Done():
+			return c.db.ctx.Err()
+		}
+	}
+}
+
+// indexAfterWrite updates the indexes and calls the after write hooks once the
+// transaction is committed. The journal is cleared once all indexes are done.
+func (c *Collection) indexAfterWrite(tr *transaction.Transaction, journal []*transaction.Operation) (err error) {
+	c.markForBuildingIndexes(tr)
+
+	syncIndexes, queued, err := c.queueForIndexing(tr, journal)
+	if err != nil {
+		return err
+	}
+
+	for _, index := range syncIndexes {
+		err = index.indexOperations(tr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !queued {
+		err = c.clearIndexJournal(journal)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.hooks.runAfter(tr)
+
+	return nil
+}
+
+// queueForIndexing queues the transaction for the asynchronous indexes and returns
+// the synchronous ones. queued is false if there is no asynchronous index.
+func (c *Collection) queueForIndexing(tr *transaction.Transaction, journal []*transaction.Operation) (syncIndexes []*BleveIndex, queued bool, _ error) {
+	c.indexing.queueLock.Lock()
+	defer c.indexing.queueLock.Unlock()
+
+	asyncIndexes := map[string]bool{}
+	for _, index := range c.getBleveIndexes() {
+		if index.Async {
+			asyncIndexes[index.Name] = true
+		} else {
+			syncIndexes = append(syncIndexes, index)
+		}
+	}
+	if len(asyncIndexes) == 0 {
+		return syncIndexes, false, nil
+	}
+
+	if c.indexing.queue == nil {
+		c.indexing.queue = make(chan *indexingJob, 1000)
+		go c.goRoutineLoopForIndexing(c.indexing.queue)
+	}
+
+	// The state lock is not kept while waiting for the queue because the
+	// indexing loop needs it to make progress
+	c.indexing.lock.Lock()
+	if c.indexing.indexedChan == nil {
+		c.indexing.indexedChan = make(chan struct{})
+	}
+	c.indexing.version++
+	job := &indexingJob{
+		version: c.indexing.version,
+		tr:      tr,
+		journal: journal,
+		indexes: asyncIndexes,
+	}
+	c.indexing.lock.Unlock()
+
+	select {
+	case c.indexing.queue <- job:
+	case <-c.db.ctx.Done():
+		return nil, false, c.db.ctx.Err()
+	}
+
+	return syncIndexes, true, nil
+}
+
+// goRoutineLoopForIndexing updates the asynchronous indexes.
+// If the database is closed before the end the journal replays the remaining jobs at the next start.
+func (c *Collection) goRoutineLoopForIndexing(queue chan *indexingJob) {
+	for {
+		select {
+		case job := <-queue:
+			var err error
+			for _, index := range c.getBleveIndexes() {
+				if !job.indexes[index.Name] {
+					continue
+				}
+
+				err = index.indexOperations(job.tr)
+				if err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = c.clearIndexJournal(job.journal)
+			}
+
+			c.indexing.lock.Lock()
+			c.indexing.indexedVersion = job.version
+			if err != nil {
+				c.indexing.err = err
+			}
+			close(c.indexing.indexedChan)
+			c.indexing.indexedChan = make(chan struct{})
+			c.indexing.lock.Unlock()
+		case <-c.db.ctx.Done():
+			return
+		}
+	}
+}
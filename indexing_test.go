@@ -0,0 +1,163 @@
+package gotinydb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+)
+
+func TestAsyncIndexing(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	err = testCol.SetIndexAsync(testIndexName, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = testCol.SetIndexAsync("does not exist", true)
+	if err != ErrIndexNotFound {
+		t.Errorf("expected %v but got %v", ErrIndexNotFound, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < 100; i++ {
+		user := &testUserStruct{fmt.Sprintf("name %d", i), fmt.Sprintf("user%d@async.org", i), nil}
+		err = testCol.Put(fmt.Sprintf("async user %d", i), user)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	version := testCol.Version()
+	if version != 100 {
+		t.Errorf("expected version 100 but got %d", version)
+		return
+	}
+
+	err = testCol.WaitIndexed(ctx, version)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = testCol.Search(testIndexName, bleve.NewQueryStringQuery("user99@async.org"))
+	if err != nil {
+		t.Errorf("the last document must be indexed: %s", err.Error())
+		return
+	}
+
+	// Read your writes with the search request option
+	err = testCol.Put("async last", &testUserStruct{"last", "last@async.org", nil})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	searchRequest := &SearchRequest{
+		SearchRequest: bleve.NewSearchRequest(bleve.NewQueryStringQuery("last@async.org")),
+		WaitIndexed:   true,
+	}
+	var searchResult *SearchResult
+	searchResult, err = testCol.SearchWithRequest(ctx, testIndexName, searchRequest)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if searchResult.BleveSearchResult.Hits[0].ID != "async last" {
+		t.Errorf("expected %q but got %q", "async last", searchResult.BleveSearchResult.Hits[0].ID)
+		return
+	}
+
+	// The index must stay asynchronous after a restart
+	testDB.Close()
+	testDB, err = Open(testPath, testConfigKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	testCol, err = testDB.Use(testColName)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var index *BleveIndex
+	index, err = testCol.GetBleveIndex(testIndexName)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !index.Async {
+		t.Errorf("the index must be asynchronous")
+		return
+	}
+
+	// The writes done while the index becomes synchronous are not lost
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 50; i++ {
+			err := testCol.Put(fmt.Sprintf("switch user %d", i), &testUserStruct{"switch", fmt.Sprintf("user%d@switch.org", i), nil})
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	err = testCol.SetIndexAsync(testIndexName, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = <-done
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 50; i++ {
+		_, err = testCol.Search(testIndexName, bleve.NewQueryStringQuery(fmt.Sprintf("user%d@switch.org", i)))
+		if err != nil {
+			t.Errorf("the document %d must be indexed: %s", i, err.Error())
+			return
+		}
+	}
+}
+
+func TestIndexingError(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	indexingErr := fmt.Errorf("indexing error")
+	testCol.indexing.lock.Lock()
+	testCol.indexing.err = indexingErr
+	testCol.indexing.lock.Unlock()
+
+	// The error is kept until it's cleared
+	for i := 0; i < 2; i++ {
+		err = testCol.WaitIndexed(context.Background(), 0)
+		if err != indexingErr {
+			t.Errorf("expected %v but got %v", indexingErr, err)
+			return
+		}
+	}
+
+	testCol.ClearIndexingError()
+	err = testCol.WaitIndexed(context.Background(), 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+}
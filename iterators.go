@@ -1,9 +1,9 @@
 package gotinydb
 
 import (
+	"bytes"
 	"encoding/json"
 
-	"github.com/alexandrestein/gotinydb/cipher"
 	"github.com/dgraph-io/badger"
 )
 
@@ -12,6 +12,10 @@ type (
 		txn        *badger.Txn
 		badgerIter *badger.Iterator
 		item       *badger.Item
+		// sharedTxn is true if the transaction is not owned by the iterator
+		sharedTxn bool
+		// err is the first error met while reading the values
+		err error
 	}
 
 	// CollectionIterator provides a nice way to list elements
@@ -20,6 +24,50 @@ type (
 
 		c         *Collection
 		colPrefix []byte
+
+		// bounds is set by *Collection.IterateRange
+		bounds *iterBounds
+	}
+
+	// IterOptions defines the options of *Collection.IterateRange
+	IterOptions struct {
+		// ExcludeTo excludes the upper bound of the range
+		ExcludeTo bool
+		// Prefix limits the iteration to the ids starting with the prefix
+		Prefix string
+		// Limit is the maximum number of documents returned, 0 means no limit
+		Limit int
+		// KeysOnly does not load the documents when the iterator moves.
+		// They are still loaded by *CollectionIterator.GetValue and *CollectionIterator.GetBytes.
+		KeysOnly bool
+		// Filter skips the documents for which it returns false.
+		// The document is decoded only if decode is called.
+		Filter func(id string, decode func(dest interface{}) error) bool
+	}
+
+	iterBounds struct {
+		opts IterOptions
+		// to is the upper bound as a database key, nil means no bound
+		to []byte
+		// prefix is the prefix of the ids as a database key
+		prefix []byte
+		// count is the number of documents already returned
+		count int
+		// checkedKey is the key of the position validated by Valid
+		checkedKey []byte
+	}
+
+	// IndexIterator lists the documents of an ordered index lookup.
+	// It is returned by *Collection.Lookup and *Collection.RangeLookup.
+	IndexIterator struct {
+		*baseIterator
+
+		c *Collection
+		// prefix is the prefix of the entries of the index
+		prefix []byte
+		// from and to are the encoded bounds of the lookup, nil means no bound
+		from, to []byte
+		id       string
 	}
 
 	// FileIterator provides easy access to all written files
@@ -46,36 +94,87 @@ func (i *baseIterator) valid(prefix []byte) bool {
 	return true
 }
 
+// setErr saves the error if it's the first one
+func (i *baseIterator) setErr(err error) {
+	if err != nil && i.err == nil {
+		i.err = err
+	}
+}
+
+// Err returns the first error met by the iterator while reading the values.
+// It should be checked once the iteration is done because the methods which do not
+// return an error, like *CollectionIterator.GetValue, only save it here.
+// ErrIntegrity is returned if a value can't be decrypted.
+func (i *baseIterator) Err() error {
+	return i.err
+}
+
 // Close closes the current iterator and it's related components.
 // This method needs to be called ones the iterator is no more needed.
 func (i *baseIterator) Close() {
 	i.badgerIter.Close()
-	i.txn.Discard()
+	if !i.sharedTxn {
+		i.txn.Discard()
+	}
 }
 
-func (i *CollectionIterator) get(dest interface{}) []byte {
+func (i *CollectionIterator) get(dest interface{}) ([]byte, error) {
+	if !i.Valid() {
+		return nil, nil
+	}
+	return i.decodeItem(dest)
+}
+
+// decodeItem reads the document of the current item without checking the position,
+// it's used by Valid to give the document to the filter
+func (i *CollectionIterator) decodeItem(dest interface{}) (_ []byte, err error) {
 	caller := new(multiGetCaller)
-	caller.id = i.GetID()
-	caller.dbID = i.getDBKey()
+	caller.dbID = i.item.KeyCopy(nil)
+	caller.id = string(caller.dbID[len(i.colPrefix):])
 	caller.pointer = dest
 
-	caller.encryptedAsBytes, _ = i.item.ValueCopy(caller.encryptedAsBytes)
+	defer func() {
+		i.setErr(err)
+	}()
+
+	caller.encryptedAsBytes, err = i.item.ValueCopy(caller.encryptedAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	caller.userMeta = i.item.UserMeta()
 
-	i.c.decryptAndUnmarshal(caller)
+	err = i.c.decryptAndUnmarshal(caller)
+	if err != nil {
+		return nil, err
+	}
 
-	return caller.asBytes
+	return caller.asBytes, nil
 }
 
-// GetBytes returns the document as a slice of bytes
+// GetBytes returns the document as a slice of bytes.
+// If the document can't be read it returns nil and the error is given by *CollectionIterator.Err.
 func (i *CollectionIterator) GetBytes() []byte {
-	return i.get(nil)
+	asBytes, _ := i.get(nil)
+	return asBytes
 }
 
-// GetValue tries to fill-up the dest pointer with the coresponding document
+// GetValue tries to fill-up the dest pointer with the coresponding document.
+// If the document can't be read the error is given by *CollectionIterator.Err.
 func (i *CollectionIterator) GetValue(dest interface{}) {
 	i.get(dest)
 }
 
+// Bytes is like *CollectionIterator.GetBytes but it returns the error
+func (i *CollectionIterator) Bytes() ([]byte, error) {
+	return i.get(nil)
+}
+
+// Value is like *CollectionIterator.GetValue but it returns the error
+func (i *CollectionIterator) Value(dest interface{}) error {
+	_, err := i.get(dest)
+	return err
+}
+
 func (i *CollectionIterator) getDBKey() []byte {
 	if !i.Valid() {
 		return nil
@@ -103,13 +202,56 @@ func (i *CollectionIterator) GetID() string {
 // it will move to the smallest bigger key than the current one. If the iterator is
 // in reverted mode it will move to the biggest smaller key than the current one.
 func (i *CollectionIterator) Next() {
+	if i.bounds != nil && i.bounds.checkedKey != nil {
+		i.bounds.count++
+		i.bounds.checkedKey = nil
+	}
+
 	i.badgerIter.Next()
 }
 
 // Valid returns true if the cursor still on valid value.
 // It returns false if the iteration is done
 func (i *CollectionIterator) Valid() bool {
-	return i.valid(i.colPrefix)
+	if i.bounds == nil {
+		return i.valid(i.colPrefix)
+	}
+
+	for {
+		if !i.valid(i.bounds.prefix) {
+			return false
+		}
+
+		key := i.item.Key()
+		if i.bounds.checkedKey != nil && bytes.Equal(i.bounds.checkedKey, key) {
+			return true
+		}
+
+		if i.bounds.opts.Limit > 0 && i.bounds.count >= i.bounds.opts.Limit {
+			return false
+		}
+
+		if i.bounds.to != nil {
+			cmp := bytes.Compare(key, i.bounds.to)
+			if cmp > 0 || (cmp == 0 && i.bounds.opts.ExcludeTo) {
+				return false
+			}
+		}
+
+		if i.bounds.opts.Filter != nil {
+			decode := func(dest interface{}) error {
+				_, err := i.decodeItem(dest)
+				return err
+			}
+			if !i.bounds.opts.Filter(string(key[len(i.colPrefix):]), decode) {
+				i.badgerIter.Next()
+				continue
+			}
+		}
+
+		i.bounds.checkedKey = i.item.KeyCopy(nil)
+		return true
+	}
 }
 
 // Seek would seek to the provided key if present.
@@ -130,6 +272,9 @@ func (i *FileIterator) Next() error {
 
 goToNext:
 	if !i.Valid() {
+		if i.err != nil {
+			return i.err
+		}
 		return ErrFileItemIteratorNotValid
 	}
 
@@ -151,11 +296,20 @@ func (i *FileIterator) Seek(id string) {
 	i.badgerIter.Seek(i.db.buildFilePrefix(id, 0))
 }
 
-// Valid checks if the cursor point a valid metadata document
+// Valid checks if the cursor point a valid metadata document.
+// It returns false if the metadata can't be read, the error is given by *FileIterator.Err.
 func (i *FileIterator) Valid() bool {
+	if i.err != nil {
+		return false
+	}
+
 	valid := i.valid([]byte{prefixFiles})
 	if valid {
-		i.isMetaChunk()
+		_, err := i.isMetaChunk()
+		if err != nil {
+			i.setErr(err)
+			return false
+		}
 	}
 	return valid
 }
@@ -189,10 +343,67 @@ func (i *FileIterator) decrypt() ([]byte, error) {
 	}
 
 	var valAsBytes []byte
-	valAsBytes, err = cipher.Decrypt(i.db.PrivateKey, i.item.Key(), valAsEncryptedBytes)
+	valAsBytes, err = i.db.decryptData(i.item.Key(), valAsEncryptedBytes)
 	if err != nil {
 		return nil, err
 	}
 
 	return valAsBytes, nil
 }
+
+// GetID returns the id of the document at the current position
+func (i *IndexIterator) GetID() string {
+	return i.id
+}
+
+func (i *IndexIterator) get(dest interface{}) ([]byte, error) {
+	asBytes, err := i.c.get(i.txn, i.id, dest)
+	i.setErr(err)
+	return asBytes, err
+}
+
+// GetBytes returns the document at the current position as a slice of bytes.
+// If the document can't be read it returns nil and the error is given by *IndexIterator.Err.
+func (i *IndexIterator) GetBytes() []byte {
+	asBytes, _ := i.get(nil)
+	return asBytes
+}
+
+// GetValue fills up the dest pointer with the document at the current position.
+// If the document can't be read the error is given by *IndexIterator.Err.
+func (i *IndexIterator) GetValue(dest interface{}) {
+	i.get(dest)
+}
+
+// Bytes is like *IndexIterator.GetBytes but it returns the error
+func (i *IndexIterator) Bytes() ([]byte, error) {
+	return i.get(nil)
+}
+
+// Value is like *IndexIterator.GetValue but it returns the error
+func (i *IndexIterator) Value(dest interface{}) error {
+	_, err := i.get(dest)
+	return err
+}
+
+// Next moves the cursor to the next entry of the index
+func (i *IndexIterator) Next() {
+	i.badgerIter.Next()
+}
+
+// Valid returns true if the cursor is on an entry matching the lookup.
+// It returns false if the iteration is done.
+func (i *IndexIterator) Valid() bool {
+	if !i.valid(i.prefix) {
+		return false
+	}
+
+	entry := i.item.Key()[len(i.prefix):]
+	n := orderedValueLen(entry)
+	if !inOrderedRange(entry[:n], i.from, i.to) {
+		return false
+	}
+
+	i.id = string(entry[n:])
+	return true
+}
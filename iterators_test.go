@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+
+	"github.com/dgraph-io/badger"
 )
 
 func TestCollectionIterator(t *testing.T) {
@@ -107,3 +109,143 @@ func TestFileIterator(t *testing.T) {
 		t.Errorf("this test must loop %d times but it looks like it did only %d", 5, n)
 	}
 }
+
+func TestIterateRange(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	var col *Collection
+	col, err = testDB.Use("range")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 10; i++ {
+		err = col.Put(fmt.Sprintf("a%d", i), &testUserStruct{Name: fmt.Sprintf("user %d", i)})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		err = col.Put(fmt.Sprintf("b%d", i), &testUserStruct{Name: fmt.Sprintf("user %d", i)})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	listIDs := func(iter *CollectionIterator) []string {
+		defer iter.Close()
+
+		ids := []string{}
+		for ; iter.Valid(); iter.Next() {
+			// Valid can be called many times at the same position
+			iter.Valid()
+			ids = append(ids, iter.GetID())
+		}
+		return ids
+	}
+	check := func(expected, got []string) {
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("expected %v but got %v", expected, got)
+		}
+	}
+
+	check([]string{"a3", "a4", "a5"}, listIDs(col.IterateRange("a3", "a5", IterOptions{})))
+	check([]string{"a3", "a4"}, listIDs(col.IterateRange("a3", "a5", IterOptions{ExcludeTo: true})))
+	check([]string{"a8", "a9", "b0", "b1"}, listIDs(col.IterateRange("a8", "b1", IterOptions{})))
+	check([]string{"b0", "b1", "b2"}, listIDs(col.IterateRange("", "", IterOptions{Prefix: "b", Limit: 3})))
+	check([]string{"b7", "b8", "b9"}, listIDs(col.IterateRange("b7", "", IterOptions{KeysOnly: true})))
+
+	// The filter decodes the documents
+	filter := func(id string, decode func(dest interface{}) error) bool {
+		user := new(testUserStruct)
+		if decode(user) != nil {
+			return false
+		}
+		return user.Name == "user 2" || user.Name == "user 4"
+	}
+	check([]string{"a2", "a4", "b2"}, listIDs(col.IterateRange("", "", IterOptions{Filter: filter, Limit: 3})))
+
+	// The filter gets the ids without decoding
+	idFilter := func(id string, decode func(dest interface{}) error) bool {
+		return id[1] == '7'
+	}
+	check([]string{"a7", "b7"}, listIDs(col.IterateRange("", "", IterOptions{Filter: idFilter})))
+
+	// The documents are loaded in keys only mode
+	iter := col.IterateRange("a1", "a1", IterOptions{KeysOnly: true})
+	defer iter.Close()
+	user := new(testUserStruct)
+	iter.GetValue(user)
+	if user.Name != "user 1" {
+		t.Errorf("expected %q but got %q", "user 1", user.Name)
+	}
+}
+
+func TestIteratorIntegrity(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	// Change one byte of the encrypted document directly in the store
+	dbKey := testCol.buildDBKey(testUserID)
+	err = testDB.badger.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(dbKey)
+		if err != nil {
+			return err
+		}
+		var encrypted []byte
+		encrypted, err = item.ValueCopy(encrypted)
+		if err != nil {
+			return err
+		}
+		encrypted[len(encrypted)-1] ^= 0xFF
+
+		return txn.SetWithMeta(dbKey, encrypted, item.UserMeta())
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = testCol.Get(testUserID, nil)
+	if err != ErrIntegrity {
+		t.Errorf("expected %v but got %v", ErrIntegrity, err)
+		return
+	}
+
+	iter := testCol.GetIterator()
+	defer iter.Close()
+	n := 0
+	for ; iter.Valid(); iter.Next() {
+		user := new(testUserStruct)
+		if iter.GetID() == testUserID {
+			if err = iter.Value(user); err != ErrIntegrity {
+				t.Errorf("expected %v but got %v", ErrIntegrity, err)
+			}
+			if _, err = iter.Bytes(); err != ErrIntegrity {
+				t.Errorf("expected %v but got %v", ErrIntegrity, err)
+			}
+			continue
+		}
+
+		iter.GetValue(user)
+		if user.Name != cloneTestUser.Name {
+			t.Errorf("expected %q but got %q", cloneTestUser.Name, user.Name)
+		}
+		n++
+	}
+	if n != 1 {
+		t.Errorf("expected 1 readable document but got %d", n)
+	}
+
+	if iter.Err() != ErrIntegrity {
+		t.Errorf("expected the iterator error to be %v but got %v", ErrIntegrity, iter.Err())
+	}
+}
@@ -0,0 +1,131 @@
+package gotinydb
+
+import (
+	"context"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"github.com/alexandrestein/gotinydb/transaction"
+	"github.com/dgraph-io/badger"
+)
+
+// journalSequence makes every journal entry unique even if the same document
+// is updated many times before the indexes are done
+var journalSequence = uint64(time.Now().UnixNano())
+
+func (c *Collection) buildIndexJournalPrefix() []byte {
+	prefix := make([]byte, len(c.Prefix), len(c.Prefix)+1)
+	copy(prefix, c.Prefix)
+	return append(prefix, prefixCollectionsIndexJournal)
+}
+
+// buildIndexJournalOperations returns the operations which save the ids of the documents
+// to index. They are written in the same commit as the documents and removed once the indexes
+// are done. If the process stops in between they are replayed when the database is opened.
+func (c *Collection) buildIndexJournalOperations(ops []*transaction.Operation) []*transaction.Operation {
+	if len(c.getBleveIndexes()) == 0 {
+		return nil
+	}
+
+	ret := make([]*transaction.Operation, len(ops))
+	for i, op := range ops {
+		key := c.buildIndexJournalPrefix()
+		seq := make([]byte, 8)
+		binary.BigEndian.PutUint64(seq, atomic.AddUint64(&journalSequence, 1))
+		key = append(key, seq...)
+		key = append(key, op.CollectionID...)
+
+		ret[i] = transaction.NewOperation(op.CollectionID, nil, key, []byte{}, false, true)
+	}
+
+	return ret
+}
+
+// clearIndexJournal removes the given journal entries once the indexes are up to date
+func (c *Collection) clearIndexJournal(journal []*transaction.Operation) error {
+	if len(journal) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(c.db.ctx)
+	defer cancel()
+
+	tr := transaction.New(ctx)
+	for _, op := range journal {
+		tr.AddOperation(
+			transaction.NewOperation(op.CollectionID, nil, op.DBKey, nil, true, true),
+		)
+	}
+
+	return c.putSendToWriteAndWaitForResponse(tr)
+}
+
+// replayIndexJournal indexes the documents which were saved but not indexed
+// because the database was not closed properly
+func (c *Collection) replayIndexJournal() error {
+	prefix := c.buildIndexJournalPrefix()
+
+	journal := []*transaction.Operation{}
+	err := c.db.badger.View(func(txn *badger.Txn) error {
+		opt := badger.DefaultIteratorOptions
+		opt.PrefetchValues = false
+		iter := txn.NewIterator(opt)
+		defer iter.Close()
+
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			var key []byte
+			key = iter.Item().KeyCopy(key)
+
+			// The id is after the prefix and the sequence
+			id := string(key[len(prefix)+8:])
+			journal = append(journal, transaction.NewOperation(id, nil, key, nil, false, true))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(journal) == 0 {
+		return nil
+	}
+
+	done := map[string]bool{}
+	for _, op := range journal {
+		if done[op.CollectionID] {
+			continue
+		}
+		done[op.CollectionID] = true
+
+		err = c.reindexDocument(c.getBleveIndexes(), op.CollectionID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.clearIndexJournal(journal)
+}
+
+// reindexDocument updates the given indexes with the document as it is saved now.
+// The document is removed from the indexes if it does not exist anymore.
+func (c *Collection) reindexDocument(indexes []*BleveIndex, id string) error {
+	contentAsBytes, err := c.Get(id, nil)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	deleted := err == badger.ErrKeyNotFound
+
+	for _, index := range indexes {
+		if deleted {
+			err = index.bleveIndex.Delete(id)
+		} else {
+			err = index.bleveIndex.Index(id, c.fromValueBytesGetContentToIndex(contentAsBytes))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package gotinydb
+
+import (
+	"encoding/base64"
+
+	"github.com/alexandrestein/gotinydb/cipher"
+)
+
+type (
+	// ListResult is a page of documents returned by *Collection.List and *Collection.ListReverse
+	ListResult struct {
+		IDs      []string
+		Contents [][]byte
+		// Cursor permits to get the next page. It's empty when there is no more document.
+		Cursor string
+	}
+)
+
+// Those constants are saved into the cursors to keep the order of the listing
+const (
+	listForward byte = iota
+	listReverse
+)
+
+// List returns up to limit documents in the order of the ids starting after the cursor.
+// An empty cursor starts at the first document and a limit of 0 returns all documents.
+// The cursor is encrypted and can be given to the clients without exposing the ids.
+func (c *Collection) List(cursor string, limit int) (*ListResult, error) {
+	return c.list(cursor, limit, listForward)
+}
+
+// ListReverse does the same as *Collection.List in the oposite order.
+// The cursors of the two orders can't be mixed.
+func (c *Collection) ListReverse(cursor string, limit int) (*ListResult, error) {
+	return c.list(cursor, limit, listReverse)
+}
+
+func (c *Collection) list(cursor string, limit int, direction byte) (*ListResult, error) {
+	iter := c.getIterator(direction == listReverse)
+	defer iter.Close()
+
+	if cursor == "" {
+		if direction == listReverse {
+			iter.badgerIter.Seek(c.buildJustTooBigDBPrefix())
+		} else {
+			iter.badgerIter.Seek(iter.colPrefix)
+		}
+	} else {
+		lastID, err := c.decodeCursor(cursor, direction)
+		if err != nil {
+			return nil, err
+		}
+
+		// Resume after the last returned document even if it was removed
+		iter.Seek(lastID)
+		if iter.Valid() && iter.GetID() == lastID {
+			iter.Next()
+		}
+	}
+
+	ret := new(ListResult)
+	for ; iter.Valid(); iter.Next() {
+		if limit > 0 && len(ret.IDs) >= limit {
+			ret.Cursor = c.encodeCursor(ret.IDs[len(ret.IDs)-1], direction)
+			break
+		}
+
+		content, err := iter.Bytes()
+		if err != nil {
+			return nil, err
+		}
+
+		ret.IDs = append(ret.IDs, iter.GetID())
+		ret.Contents = append(ret.Contents, content)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func (c *Collection) encodeCursor(lastID string, direction byte) string {
+	clear := append([]byte{direction}, lastID...)
+	return base64.RawURLEncoding.EncodeToString(cipher.Encrypt(c.db.PrivateKey, c.Prefix, clear))
+}
+
+func (c *Collection) decodeCursor(cursor string, direction byte) (string, error) {
+	encrypted, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+
+	clear, err := cipher.Decrypt(c.db.PrivateKey, c.Prefix, encrypted)
+	if err != nil || len(clear) == 0 || clear[0] != direction {
+		return "", ErrInvalidCursor
+	}
+
+	return string(clear[1:]), nil
+}
@@ -0,0 +1,114 @@
+package gotinydb
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestList(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	var col *Collection
+	col, err = testDB.Use("list")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := []string{}
+	for i := 0; i < 25; i++ {
+		id := fmt.Sprintf("id %02d", i)
+		expected = append(expected, id)
+		err = col.Put(id, &testUserStruct{Name: id})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	listAll := func(list func(cursor string, limit int) (*ListResult, error)) []string {
+		ids := []string{}
+		cursor := ""
+		pages := 0
+		for {
+			result, err := list(cursor, 10)
+			if err != nil {
+				t.Error(err)
+				return nil
+			}
+			pages++
+			ids = append(ids, result.IDs...)
+
+			if result.Cursor == "" {
+				break
+			}
+			cursor = result.Cursor
+
+			// Documents removed between two pages don't break the listing
+			if pages == 1 {
+				col.Delete(result.IDs[len(result.IDs)-1])
+			}
+		}
+		if pages != 3 {
+			t.Errorf("expected 3 pages but got %d", pages)
+		}
+		return ids
+	}
+
+	got := listAll(col.List)
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v but got %v", expected, got)
+		return
+	}
+
+	// The reverse listing does not have the document removed during the first listing
+	expectedReverse := []string{}
+	for i := len(expected) - 1; i >= 0; i-- {
+		if expected[i] == "id 09" {
+			continue
+		}
+		expectedReverse = append(expectedReverse, expected[i])
+	}
+	got = listAll(col.ListReverse)
+	if !reflect.DeepEqual(expectedReverse, got) {
+		t.Errorf("expected %v but got %v", expectedReverse, got)
+		return
+	}
+
+	result, err := col.List("", 2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = col.ListReverse(result.Cursor, 2)
+	if err != ErrInvalidCursor {
+		t.Errorf("expected %v but got %v", ErrInvalidCursor, err)
+		return
+	}
+	_, err = testCol.List(result.Cursor, 2)
+	if err != ErrInvalidCursor {
+		t.Errorf("the cursor of an other collection must be refused but got %v", err)
+		return
+	}
+
+	// The documents which can't be read make the listing fail
+	err = testDB.badger.Update(func(txn *badger.Txn) error {
+		return txn.Set(col.buildDBKey("id 00"), []byte("not encrypted"))
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = col.List("", 2)
+	if err != ErrIntegrity {
+		t.Errorf("expected %v but got %v", ErrIntegrity, err)
+		return
+	}
+}
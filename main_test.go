@@ -2,14 +2,21 @@ package gotinydb
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/alexandrestein/gotinydb/cipher"
+	"github.com/alexandrestein/gotinydb/compress"
+	"github.com/alexandrestein/gotinydb/transaction"
 	"github.com/blevesearch/bleve"
 	"github.com/dgraph-io/badger"
 )
@@ -443,3 +450,151 @@ func TestChainReOpen(t *testing.T) {
 		t.Fatal("the index exist")
 	}
 }
+
+func TestCompression(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	err = testCol.SetCompression(compress.Zstd+1, 0)
+	if err != compress.ErrUnknownAlgorithm {
+		t.Errorf("expected %v but got %v", compress.ErrUnknownAlgorithm, err)
+		return
+	}
+
+	longText := strings.Repeat("this text must be compressed ", 1000)
+
+	for i, algorithm := range []byte{compress.Snappy, compress.Zstd} {
+		err = testCol.SetCompression(algorithm, 3)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		id := fmt.Sprintf("compressed %d", i)
+		err = testCol.Put(id, []byte(longText))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		var contentAsBytes []byte
+		contentAsBytes, err = testCol.Get(id, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(contentAsBytes) != longText {
+			t.Errorf("the content is not the one saved")
+			return
+		}
+
+		testDB.badger.View(func(txn *badger.Txn) error {
+			item, err := txn.Get(testCol.buildDBKey(id))
+			if err != nil {
+				t.Error(err)
+				return err
+			}
+			clear, err := testDB.decryptItem(item)
+			if err != nil {
+				t.Error(err)
+				return err
+			}
+			if clear[0] != algorithm {
+				t.Errorf("the record must be compressed with %d but is %d", algorithm, clear[0])
+			}
+			if size := item.EstimatedSize(); size >= int64(len(longText)) {
+				t.Errorf("the record is %d bytes long and is not compressed", size)
+			}
+			return nil
+		})
+	}
+
+	// The documents saved before the compression header have no header and are still readable
+	oldFormatKey := testCol.buildDBKey("old format")
+	oldFormatContent, _ := json.Marshal(testUser)
+	err = testDB.badger.Update(func(txn *badger.Txn) error {
+		return txn.Set(oldFormatKey, cipher.Encrypt(testDB.PrivateKey, oldFormatKey, oldFormatContent))
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	retrievedUser := new(testUserStruct)
+	_, err = testCol.Get("old format", retrievedUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(retrievedUser, testUser) {
+		t.Errorf("the document saved without header is not readable. Put %v and get %v", testUser, retrievedUser)
+		return
+	}
+
+	// The documents saved before the compression are still readable
+	retrievedUser = new(testUserStruct)
+	_, err = testCol.Get(testUserID, retrievedUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(retrievedUser, testUser) {
+		t.Errorf("the users are not equal. Put %v and get %v", testUser, retrievedUser)
+		return
+	}
+
+	iter := testCol.GetIterator()
+	defer iter.Close()
+	n := 0
+	for ; iter.Valid(); iter.Next() {
+		if len(iter.GetBytes()) == 0 {
+			t.Errorf("the document %q can't be read", iter.GetID())
+		}
+		n++
+	}
+	if n != 5 {
+		t.Errorf("expected 5 documents but got %d", n)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	hookErr := errors.New("hook error")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first transaction is valid and the second one fails after the write of its document
+	valid := transaction.New(ctx)
+	valid.AddOperation(transaction.NewOperation("valid", nil, testCol.buildDBKey("valid"), []byte(`{"name":"valid"}`), false, false))
+	failing := transaction.New(ctx)
+	failingOp := transaction.NewOperation("failing", nil, testCol.buildDBKey("failing"), []byte(`{"name":"failing"}`), false, false)
+	failingOp.Hook = func(txn *badger.Txn) error {
+		return hookErr
+	}
+	failing.AddOperation(failingOp)
+
+	go testDB.writeTransactions([]*transaction.Transaction{valid, failing})
+
+	if err = <-valid.ResponseChan; err != nil {
+		t.Errorf("the valid transaction must be written but got %v", err)
+	}
+	if err = <-failing.ResponseChan; err != hookErr {
+		t.Errorf("expected %v but got %v", hookErr, err)
+	}
+
+	_, err = testCol.Get("valid", nil)
+	if err != nil {
+		t.Errorf("the document of the valid transaction must be saved but got %v", err)
+	}
+	_, err = testCol.Get("failing", nil)
+	if err != ErrNotFound {
+		t.Errorf("the document of the failing transaction must not be saved but got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package gotinydb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/mapping"
+)
+
+// structTagName is the name of the struct tag read by NewIndexMappingFromStruct
+const structTagName = "gotinydb"
+
+// Those are the types of field set with the "type" option of the struct tag
+const (
+	FieldTypeText     = "text"
+	FieldTypeKeyword  = "keyword"
+	FieldTypeNumeric  = "numeric"
+	FieldTypeDatetime = "datetime"
+	FieldTypeBoolean  = "boolean"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// NewIndexMappingFromStruct builds a bleve index mapping from the struct tags of the sample.
+// Only the fields with the tag are indexed:
+//
+//	Email string    `json:"email" gotinydb:"index,type=keyword"`
+//	Bio   string    `json:"bio" gotinydb:"index,type=text,analyzer=en,store"`
+//	Age   int       `json:"age" gotinydb:"index"`
+//
+// If the type is not given it's found from the Go type of the field. The field name is
+// taken from the json tag like bleve does. The nested structs are mapped as sub documents.
+// The mapping is the default mapping, this way the documents indexed from maps don't get
+// the dynamic mapping of bleve which indexes every field.
+// If the sample implements the Type() string method the mapping is also used for this type.
+func NewIndexMappingFromStruct(sample interface{}) (*mapping.IndexMappingImpl, error) {
+	sampleType := reflect.TypeOf(sample)
+	for sampleType != nil && sampleType.Kind() == reflect.Ptr {
+		sampleType = sampleType.Elem()
+	}
+	if sampleType == nil || sampleType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("the sample must be a struct but got %T", sample)
+	}
+
+	documentMapping, err := documentMappingFromStruct(sampleType, map[reflect.Type]bool{})
+	if err != nil {
+		return nil, err
+	}
+
+	indexMapping := bleve.NewIndexMapping()
+	indexMapping.DefaultMapping = documentMapping
+	if classifier, ok := sample.(interface{ Type() string }); ok {
+		indexMapping.AddDocumentMapping(classifier.Type(), documentMapping)
+	}
+
+	return indexMapping, nil
+}
+
+// SetIndexFromStruct adds a bleve index built with the struct tags of the sample.
+// See NewIndexMappingFromStruct for the tag syntax.
+func (c *Collection) SetIndexFromStruct(name string, sample interface{}) error {
+	indexMapping, err := NewIndexMappingFromStruct(sample)
+	if err != nil {
+		return err
+	}
+
+	return c.SetBleveIndex(name, indexMapping)
+}
+
+// documentMappingFromStruct builds the mapping of the struct type. The types in mapping are the
+// types of the parents, the fields of those types are not mapped again to stop on the
+// self-referential structs.
+func documentMappingFromStruct(structType reflect.Type, inMapping map[reflect.Type]bool) (*mapping.DocumentMapping, error) {
+	inMapping[structType] = true
+	defer delete(inMapping, structType)
+
+	documentMapping := bleve.NewDocumentStaticMapping()
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		// Not exported
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := jsonFieldName(field)
+		if name == "-" {
+			continue
+		}
+
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr || fieldType.Kind() == reflect.Slice || fieldType.Kind() == reflect.Array {
+			fieldType = fieldType.Elem()
+		}
+
+		tag, tagged := field.Tag.Lookup(structTagName)
+		if !tagged {
+			// The nested structs can have indexed fields
+			if fieldType.Kind() == reflect.Struct && fieldType != timeType && !inMapping[fieldType] {
+				subMapping, err := documentMappingFromStruct(fieldType, inMapping)
+				if err != nil {
+					return nil, err
+				}
+				if len(subMapping.Properties) != 0 {
+					documentMapping.AddSubDocumentMapping(name, subMapping)
+				}
+			}
+			continue
+		}
+
+		fieldMapping, err := fieldMappingFromTag(field.Name, fieldType, tag)
+		if err != nil {
+			return nil, err
+		}
+		if fieldMapping != nil {
+			documentMapping.AddFieldMappingsAt(name, fieldMapping)
+		}
+	}
+
+	return documentMapping, nil
+}
+
+func fieldMappingFromTag(fieldName string, fieldType reflect.Type, tag string) (*mapping.FieldMapping, error) {
+	options := strings.Split(tag, ",")
+	if options[0] != "index" {
+		return nil, nil
+	}
+
+	var fieldMapping *mapping.FieldMapping
+	kind := defaultFieldType(fieldType)
+	analyzer := ""
+	store := false
+
+	for _, option := range options[1:] {
+		option = strings.TrimSpace(option)
+		keyValue := strings.SplitN(option, "=", 2)
+		switch {
+		case keyValue[0] == "store" && len(keyValue) == 1:
+			store = true
+		case keyValue[0] == "type" && len(keyValue) == 2:
+			kind = keyValue[1]
+		case keyValue[0] == "analyzer" && len(keyValue) == 2:
+			analyzer = keyValue[1]
+		default:
+			return nil, fmt.Errorf("unknown index option %q on field %s", option, fieldName)
+		}
+	}
+
+	switch kind {
+	case FieldTypeText:
+		fieldMapping = bleve.NewTextFieldMapping()
+	case FieldTypeKeyword:
+		fieldMapping = bleve.NewTextFieldMapping()
+		fieldMapping.Analyzer = keyword.Name
+	case FieldTypeNumeric:
+		fieldMapping = bleve.NewNumericFieldMapping()
+	case FieldTypeDatetime:
+		fieldMapping = bleve.NewDateTimeFieldMapping()
+	case FieldTypeBoolean:
+		fieldMapping = bleve.NewBooleanFieldMapping()
+	default:
+		return nil, fmt.Errorf("unknown index type %q on field %s", kind, fieldName)
+	}
+
+	if analyzer != "" {
+		if kind != FieldTypeText {
+			return nil, fmt.Errorf("the analyzer can only be set on text fields but field %s is %s", fieldName, kind)
+		}
+		fieldMapping.Analyzer = analyzer
+	}
+	fieldMapping.Store = store
+
+	return fieldMapping, nil
+}
+
+// defaultFieldType returns the type of field from the Go type
+func defaultFieldType(fieldType reflect.Type) string {
+	if fieldType == timeType {
+		return FieldTypeDatetime
+	}
+
+	switch fieldType.Kind() {
+	case reflect.Bool:
+		return FieldTypeBoolean
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return FieldTypeNumeric
+	}
+	return FieldTypeText
+}
+
+// jsonFieldName returns the name of the field as bleve sees it
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
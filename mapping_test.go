@@ -0,0 +1,168 @@
+package gotinydb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+type (
+	testTaggedUser struct {
+		Email     string            `json:"email" gotinydb:"index,type=keyword"`
+		Bio       string            `json:"bio" gotinydb:"index,type=text,analyzer=en,store"`
+		Age       int               `json:"age" gotinydb:"index"`
+		LastLogin time.Time         `json:"lastLogin" gotinydb:"index"`
+		Address   *testTaggedAdress `json:"address"`
+		Password  string            `json:"password"`
+	}
+	testTaggedAdress struct {
+		City string `json:"city" gotinydb:"index"`
+	}
+	testTaggedNode struct {
+		Name     string            `json:"name" gotinydb:"index"`
+		Parent   *testTaggedNode   `json:"parent"`
+		Children []*testTaggedNode `json:"children"`
+	}
+)
+
+func (t *testTaggedUser) Type() string {
+	return "local.testTaggedUser"
+}
+
+func TestIndexMappingFromStruct(t *testing.T) {
+	indexMapping, err := NewIndexMappingFromStruct(new(testTaggedUser))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	documentMapping, ok := indexMapping.TypeMapping["local.testTaggedUser"]
+	if !ok {
+		t.Errorf("the mapping must be set for the type of the sample")
+		return
+	}
+	if indexMapping.DefaultMapping != documentMapping {
+		t.Errorf("the mapping must also be the default mapping")
+		return
+	}
+
+	checkField := func(name, expectedType, expectedAnalyzer string, expectedStore bool) {
+		property, ok := documentMapping.Properties[name]
+		if !ok || len(property.Fields) != 1 {
+			t.Errorf("the field %q must be mapped", name)
+			return
+		}
+		field := property.Fields[0]
+		if field.Type != expectedType || field.Analyzer != expectedAnalyzer || field.Store != expectedStore {
+			t.Errorf("unexpected mapping for %q: %s %q %v", name, field.Type, field.Analyzer, field.Store)
+		}
+	}
+	checkField("email", "text", "keyword", false)
+	checkField("bio", "text", "en", true)
+	checkField("age", "number", "", false)
+	checkField("lastLogin", "datetime", "", false)
+
+	if _, ok := documentMapping.Properties["password"]; ok {
+		t.Errorf("the not tagged fields must not be indexed")
+		return
+	}
+	if _, ok := documentMapping.Properties["address"].Properties["city"]; !ok {
+		t.Errorf("the tagged fields of the nested structs must be indexed")
+		return
+	}
+
+	_, err = NewIndexMappingFromStruct(&struct {
+		Name string `gotinydb:"index,type=unknown"`
+	}{})
+	if err == nil {
+		t.Errorf("an unknown type must return an error")
+		return
+	}
+	_, err = NewIndexMappingFromStruct("not a struct")
+	if err == nil {
+		t.Errorf("the sample must be a struct")
+		return
+	}
+}
+
+func TestIndexMappingFromRecursiveStruct(t *testing.T) {
+	indexMapping, err := NewIndexMappingFromStruct(new(testTaggedNode))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	documentMapping := indexMapping.DefaultMapping
+	if _, ok := documentMapping.Properties["name"]; !ok {
+		t.Errorf("the field %q must be mapped", "name")
+		return
+	}
+	if _, ok := documentMapping.Properties["parent"]; ok {
+		t.Errorf("the self-referential fields must not be mapped")
+		return
+	}
+}
+
+func TestSetIndexFromStruct(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	err = testCol.SetIndexFromStruct("tagged", new(testTaggedUser))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = testCol.Put("tagged user", &testTaggedUser{
+		Email:    "tagged@internet.org",
+		Bio:      "walking in the mountains",
+		Age:      30,
+		Password: "secret",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The keyword field matches only the full value
+	_, err = testCol.Search("tagged", bleve.NewTermQuery("tagged@internet.org"))
+	if err != nil {
+		t.Errorf("the keyword must match: %s", err.Error())
+		return
+	}
+	// The english analyzer stems the words
+	_, err = testCol.SearchString("tagged", "bio:walk", SearchOptions{})
+	if err != nil {
+		t.Errorf("the stemmed word must match: %s", err.Error())
+		return
+	}
+	_, err = testCol.SearchString("tagged", "password:secret", SearchOptions{})
+	if err != ErrNotFound {
+		t.Errorf("the not tagged field must not be indexed but got %v", err)
+		return
+	}
+
+	// The documents without type use the same mapping
+	err = testCol.Put("map user", map[string]interface{}{
+		"email":    "map@internet.org",
+		"password": "map secret",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = testCol.Search("tagged", bleve.NewTermQuery("map@internet.org"))
+	if err != nil {
+		t.Errorf("the keyword must match: %s", err.Error())
+		return
+	}
+	_, err = testCol.SearchString("tagged", "password:map", SearchOptions{})
+	if err != ErrNotFound {
+		t.Errorf("the not tagged field of a map must not be indexed but got %v", err)
+		return
+	}
+}
@@ -0,0 +1,674 @@
+package gotinydb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/alexandrestein/gotinydb/transaction"
+	"github.com/dgraph-io/badger"
+	"golang.org/x/crypto/blake2b"
+)
+
+type (
+	// OrderedIndex is a lightweight index saved in the database with order-preserving keys.
+	// It permits exact and range lookups with *Collection.Lookup and *Collection.RangeLookup.
+	OrderedIndex struct {
+		dbElement
+		// Unique is true if two documents can't have the same value
+		Unique bool
+
+		selector OrderedIndexSelector
+	}
+
+	// uniqueValueOwner is the document which had the value of a unique index
+	// before the write of an other document
+	uniqueValueOwner struct {
+		index *OrderedIndex
+		value []byte
+		owner string
+	}
+
+	// OrderedIndexSelector returns the values to index for the given document.
+	// The document is decoded with the ToIndex function of the codec of the collection,
+	// it's a map for the JSON documents.
+	// The values can be strings, numbers, booleans or time.Time. The numbers are indexed as float64.
+	// If no value is returned the document is not indexed.
+	OrderedIndexSelector func(document interface{}) []interface{}
+)
+
+// Those constants are the types of the encoded values.
+// The values of different types are ordered by type.
+const (
+	orderedValueBool byte = iota + 1
+	orderedValueNumber
+	orderedValueTime
+	orderedValueString
+)
+
+// Those constants separate the entries from the references of the documents
+// and from the owners of the values of the unique indexes
+const (
+	orderedIndexEntries byte = iota
+	orderedIndexReferences
+	orderedIndexUniqueValues
+)
+
+// FieldSelector returns a selector which takes the value at the given path of map documents
+func FieldSelector(path ...string) OrderedIndexSelector {
+	return func(document interface{}) []interface{} {
+		value := document
+		for _, field := range path {
+			asMap, ok := value.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			value, ok = asMap[field]
+			if !ok {
+				return nil
+			}
+		}
+
+		if asSlice, ok := value.([]interface{}); ok {
+			return asSlice
+		}
+		return []interface{}{value}
+	}
+}
+
+// SetOrderedIndex adds an ordered index to the collection or gives back the selector of an
+// existing one. The selectors are not saved, they need to be set again after every opening
+// of the database and before any write. Until then the writes of documents fail with ErrIndexNotReady.
+// The existing documents are indexed when the index is created.
+// The indexes which are not used anymore are removed with *Collection.DeleteOrderedIndex.
+func (c *Collection) SetOrderedIndex(name string, selector OrderedIndexSelector) error {
+	return c.setOrderedIndex(name, selector, false)
+}
+
+// SetUniqueIndex does the same as *Collection.SetOrderedIndex but two documents can't have
+// the same value. The writes which break the constraint fail with ErrUniqueViolation and
+// nothing of their batch is written.
+// It returns ErrUniqueViolation if existing documents already break the constraint.
+func (c *Collection) SetUniqueIndex(name string, selector OrderedIndexSelector) error {
+	return c.setOrderedIndex(name, selector, true)
+}
+
+func (c *Collection) setOrderedIndex(name string, selector OrderedIndexSelector, unique bool) error {
+	for _, index := range c.getOrderedIndexes() {
+		if index.Name == name {
+			index.selector = selector
+			return nil
+		}
+	}
+
+	indexHash := blake2b.Sum256([]byte(name))
+	prefix := make([]byte, len(c.Prefix), len(c.Prefix)+3)
+	copy(prefix, c.Prefix)
+	prefix = append(prefix, prefixCollectionsOrderedIndex)
+	prefix = append(prefix, indexHash[:2]...)
+
+	for _, index := range c.getOrderedIndexes() {
+		if reflect.DeepEqual(index.Prefix, prefix) {
+			return ErrHashCollision
+		}
+	}
+
+	index := &OrderedIndex{
+		dbElement: dbElement{
+			Name:   name,
+			Prefix: prefix,
+		},
+		Unique:   unique,
+		selector: selector,
+	}
+
+	// Add the new index to the list of index of this collection and index all existing values
+	err := c.addOrderedIndex(index)
+	if err != nil {
+		return err
+	}
+
+	return c.db.saveConfig()
+}
+
+// GetOrderedIndex returns the ordered index with the given name
+func (c *Collection) GetOrderedIndex(name string) (*OrderedIndex, error) {
+	for _, index := range c.getOrderedIndexes() {
+		if index.Name == name {
+			return index, nil
+		}
+	}
+	return nil, ErrIndexNotFound
+}
+
+// DeleteOrderedIndex removes the ordered index and its entries. Like the selectors, the indexes
+// which are not used anymore must be deleted, otherwise the writes fail with ErrIndexNotReady
+// after the next opening of the database.
+func (c *Collection) DeleteOrderedIndex(name string) error {
+	var index *OrderedIndex
+
+	// The hooks are not running while the index and its entries are removed
+	c.db.commitLock.Lock()
+	defer c.db.commitLock.Unlock()
+
+	c.orderedIndexesLock.Lock()
+	for i, tmpIndex := range c.OrderedIndexes {
+		if tmpIndex.Name == name {
+			index = tmpIndex
+
+			// The list is copied because it can be read without the lock
+			indexes := make([]*OrderedIndex, 0, len(c.OrderedIndexes)-1)
+			indexes = append(indexes, c.OrderedIndexes[:i]...)
+			c.OrderedIndexes = append(indexes, c.OrderedIndexes[i+1:]...)
+
+			break
+		}
+	}
+	c.orderedIndexesLock.Unlock()
+
+	if index == nil {
+		return ErrIndexNotFound
+	}
+
+	ops, err := c.db.prefixDeleteOperations(index.Prefix)
+	if err != nil {
+		return err
+	}
+	err = c.db.writeOperationsLocked(ops)
+	if err != nil {
+		return err
+	}
+
+	return c.db.saveConfig()
+}
+
+// Lookup returns an iterator over the documents having the given value in the index
+func (c *Collection) Lookup(indexName string, value interface{}) (*IndexIterator, error) {
+	index, err := c.GetOrderedIndex(indexName)
+	if err != nil {
+		return nil, err
+	}
+
+	encodedValue, err := encodeOrderedValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	iter := c.getIndexIterator(index, encodedValue, encodedValue)
+	iter.badgerIter.Seek(append(iter.prefix, encodedValue...))
+	return iter, nil
+}
+
+// RangeLookup returns an iterator over the documents having a value between from and to
+// in the index. Both bounds are included and a nil bound means no limit.
+func (c *Collection) RangeLookup(indexName string, from, to interface{}) (*IndexIterator, error) {
+	index, err := c.GetOrderedIndex(indexName)
+	if err != nil {
+		return nil, err
+	}
+
+	var encodedFrom, encodedTo []byte
+	if from != nil {
+		encodedFrom, err = encodeOrderedValue(from)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if to != nil {
+		encodedTo, err = encodeOrderedValue(to)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	iter := c.getIndexIterator(index, encodedFrom, encodedTo)
+	iter.badgerIter.Seek(append(iter.prefix, encodedFrom...))
+	return iter, nil
+}
+
+func (c *Collection) getIndexIterator(index *OrderedIndex, from, to []byte) *IndexIterator {
+	txn := c.db.badger.NewTransaction(false)
+
+	iterOptions := badger.DefaultIteratorOptions
+	iterOptions.PrefetchValues = false
+
+	return &IndexIterator{
+		baseIterator: &baseIterator{
+			txn:        txn,
+			badgerIter: txn.NewIterator(iterOptions),
+		},
+		c:      c,
+		prefix: index.buildKeyPrefix(orderedIndexEntries),
+		from:   from,
+		to:     to,
+	}
+}
+
+// buildOrderedIndexesHook returns the function which updates the ordered indexes
+// inside the write transaction of the document and the function which checks the unique
+// constraints once the transaction is written.
+// The indexes are read again by the hook to also update the indexes added since the
+// operation was built.
+func (c *Collection) buildOrderedIndexesHook(id string, document *indexedDocument, delete bool) (hook, check func(txn *badger.Txn) error, _ error) {
+	values := map[*OrderedIndex][]byte{}
+	// previousOwners are the owners of the unique values replaced by the hook
+	var previousOwners []*uniqueValueOwner
+	if !delete {
+		for _, index := range c.getOrderedIndexes() {
+			// The index would not be updated without selector
+			if index.selector == nil {
+				return nil, nil, ErrIndexNotReady
+			}
+
+			decoded, err := document.get()
+			if err != nil {
+				return nil, nil, err
+			}
+			values[index], err = index.encodeDocument(decoded)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if index.Unique && len(values[index]) != 0 {
+				check = func(txn *badger.Txn) error {
+					return checkUniqueIndexes(txn, id, previousOwners)
+				}
+			}
+		}
+	}
+
+	hook = func(txn *badger.Txn) error {
+		previousOwners = nil
+		for _, index := range c.getOrderedIndexes() {
+			indexValues, found := values[index]
+			if !found {
+				decoded, err := document.get()
+				if err != nil {
+					return err
+				}
+				indexValues, err = index.encodeDocument(decoded)
+				if err != nil {
+					return err
+				}
+				values[index] = indexValues
+			}
+
+			owners, err := c.db.updateOrderedIndex(txn, index, id, indexValues)
+			if err != nil {
+				return err
+			}
+			previousOwners = append(previousOwners, owners...)
+		}
+
+		// The unique indexes added since the operation was built are checked right away
+		if check == nil {
+			return checkUniqueIndexes(txn, id, previousOwners)
+		}
+		return nil
+	}
+
+	return hook, check, nil
+}
+
+// checkUniqueIndexes returns ErrUniqueViolation if one of the previous owners of the values
+// of the document still has the value once the transaction is written.
+// It's run after all operations of the transaction, an other document of the same
+// transaction can give the value away after the write of this one.
+// The entries are read by key and not with an iterator, this way badger detects the
+// conflicts with the transactions which changed the same values concurrently.
+func checkUniqueIndexes(txn *badger.Txn, id string, previousOwners []*uniqueValueOwner) error {
+	for _, previous := range previousOwners {
+		if previous.owner == "" || previous.owner == id {
+			continue
+		}
+
+		_, err := txn.Get(previous.index.buildEntryKey(previous.value, previous.owner))
+		if err == nil {
+			return ErrUniqueViolation
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getUniqueValueOwner returns the id of the document which has the value in the unique index.
+// It returns an empty string if no document has it.
+func (d *DB) getUniqueValueOwner(txn *badger.Txn, index *OrderedIndex, value []byte) (string, error) {
+	key := index.buildUniqueValueKey(value)
+
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	owner, err := item.ValueCopy(nil)
+	if err != nil {
+		return "", err
+	}
+	owner, err = d.decryptData(key, owner)
+	if err != nil {
+		return "", err
+	}
+
+	return string(owner), nil
+}
+
+// updateOrderedIndex removes the old entries of the document and adds the new ones.
+// For the unique indexes it returns the owners the values had before this write.
+func (d *DB) updateOrderedIndex(txn *badger.Txn, index *OrderedIndex, id string, values []byte) (previousOwners []*uniqueValueOwner, err error) {
+	refKey := index.buildReferenceKey(id)
+
+	item, err := txn.Get(refKey)
+	if err == nil {
+		var oldValues []byte
+		oldValues, err = item.ValueCopy(oldValues)
+		if err != nil {
+			return nil, err
+		}
+		oldValues, err = d.decryptData(refKey, oldValues)
+		if err != nil {
+			return nil, err
+		}
+
+		err = eachOrderedValue(oldValues, func(value []byte) error {
+			if index.Unique {
+				// The value can already be owned by an other document of the same transaction
+				owner, err := d.getUniqueValueOwner(txn, index, value)
+				if err != nil {
+					return err
+				}
+				if owner == id {
+					err = txn.Delete(index.buildUniqueValueKey(value))
+					if err != nil {
+						return err
+					}
+				}
+			}
+			return txn.Delete(index.buildEntryKey(value, id))
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(values) == 0 {
+			return nil, txn.Delete(refKey)
+		}
+	} else if err != badger.ErrKeyNotFound {
+		return nil, err
+	}
+
+	if index.Unique {
+		// The owners are read before being overwritten to be checked once the transaction
+		// is written. It's also needed because badger does not track the reads of the keys
+		// written by the transaction, this way the concurrent writes of the same value are in conflict.
+		err = eachOrderedValue(values, func(value []byte) error {
+			owner, err := d.getUniqueValueOwner(txn, index, value)
+			if err != nil {
+				return err
+			}
+			previousOwners = append(previousOwners, &uniqueValueOwner{
+				index: index,
+				value: value,
+				owner: owner,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, op := range index.buildOperations(id, values) {
+		err = d.writeOperation(txn, op)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return previousOwners, nil
+}
+
+// addOrderedIndex adds the index and indexes the existing documents.
+// The other writes wait until it's done.
+func (c *Collection) addOrderedIndex(index *OrderedIndex) error {
+	// The list is copied because it can be read without the lock
+	add := func() {
+		c.orderedIndexesLock.Lock()
+		c.OrderedIndexes = append(c.OrderedIndexes[:len(c.OrderedIndexes):len(c.OrderedIndexes)], index)
+		c.orderedIndexesLock.Unlock()
+	}
+	remove := func() {
+		c.orderedIndexesLock.Lock()
+		c.OrderedIndexes = c.OrderedIndexes[:len(c.OrderedIndexes)-1 : len(c.OrderedIndexes)-1]
+		c.orderedIndexesLock.Unlock()
+	}
+
+	// uniqueValues saves the ids by value to check the unique constraint
+	uniqueValues := map[string]string{}
+	return c.backfill(add, remove, func(id string, document interface{}) ([]*transaction.Operation, error) {
+		values, err := index.encodeDocument(document)
+		if err != nil {
+			return nil, err
+		}
+
+		if index.Unique {
+			err = eachOrderedValue(values, func(value []byte) error {
+				if otherID, found := uniqueValues[string(value)]; found && otherID != id {
+					return ErrUniqueViolation
+				}
+				uniqueValues[string(value)] = id
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return index.buildOperations(id, values), nil
+	})
+}
+
+// encodeDocument returns the encoded values of the document concatenated
+func (i *OrderedIndex) encodeDocument(document interface{}) ([]byte, error) {
+	if i.selector == nil || document == nil {
+		return nil, nil
+	}
+
+	ret := []byte{}
+	for _, value := range i.selector(document) {
+		// Null values are not indexed
+		if value == nil {
+			continue
+		}
+
+		encodedValue, err := encodeOrderedValue(value)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, encodedValue...)
+	}
+	return ret, nil
+}
+
+// buildOperations returns the operations which save the entries of the values
+// and the reference of the document to find them back
+func (i *OrderedIndex) buildOperations(id string, values []byte) []*transaction.Operation {
+	if len(values) == 0 {
+		return nil
+	}
+
+	ops := []*transaction.Operation{
+		transaction.NewOperation(id, nil, i.buildReferenceKey(id), values, false, true),
+	}
+	eachOrderedValue(values, func(value []byte) error {
+		ops = append(ops, transaction.NewOperation(id, nil, i.buildEntryKey(value, id), []byte{}, false, true))
+		if i.Unique {
+			ops = append(ops, transaction.NewOperation(id, nil, i.buildUniqueValueKey(value), []byte(id), false, true))
+		}
+		return nil
+	})
+	return ops
+}
+
+func (i *OrderedIndex) buildKeyPrefix(kind byte) []byte {
+	prefix := make([]byte, len(i.Prefix), len(i.Prefix)+1)
+	copy(prefix, i.Prefix)
+	return append(prefix, kind)
+}
+
+func (i *OrderedIndex) buildEntryKey(value []byte, id string) []byte {
+	key := i.buildKeyPrefix(orderedIndexEntries)
+	key = append(key, value...)
+	return append(key, id...)
+}
+
+func (i *OrderedIndex) buildReferenceKey(id string) []byte {
+	return append(i.buildKeyPrefix(orderedIndexReferences), id...)
+}
+
+// buildUniqueValueKey returns the key which saves the id of the document having the value
+func (i *OrderedIndex) buildUniqueValueKey(value []byte) []byte {
+	return append(i.buildKeyPrefix(orderedIndexUniqueValues), value...)
+}
+
+// encodeOrderedValue encodes the value to keep the order of the values in the order of the bytes
+func encodeOrderedValue(value interface{}) ([]byte, error) {
+	var number float64
+	switch typedValue := value.(type) {
+	case bool:
+		if typedValue {
+			return []byte{orderedValueBool, 1}, nil
+		}
+		return []byte{orderedValueBool, 0}, nil
+	case string:
+		ret := []byte{orderedValueString}
+		// The zeros are escaped to end the string with a zero
+		for _, b := range []byte(typedValue) {
+			if b == 0 {
+				ret = append(ret, 0, 0xFF)
+				continue
+			}
+			ret = append(ret, b)
+		}
+		return append(ret, 0, 1), nil
+	case time.Time:
+		ret := make([]byte, 9)
+		ret[0] = orderedValueTime
+		binary.BigEndian.PutUint64(ret[1:], uint64(typedValue.UnixNano())^(1<<63))
+		return ret, nil
+	default:
+		var ok bool
+		number, ok = numberValue(value)
+		if !ok {
+			return nil, ErrIndexValueType
+		}
+	}
+
+	// The sign bit is flipped for the positive numbers and all bits are flipped
+	// for the negative ones to keep the order
+	bits := math.Float64bits(number)
+	if bits&(1<<63) == 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+
+	ret := make([]byte, 9)
+	ret[0] = orderedValueNumber
+	binary.BigEndian.PutUint64(ret[1:], bits)
+	return ret, nil
+}
+
+// numberValue returns the value as a float64 if it's a number
+func numberValue(value interface{}) (float64, bool) {
+	switch typedValue := value.(type) {
+	case json.Number:
+		number, err := typedValue.Float64()
+		if err != nil {
+			return 0, false
+		}
+		return number, true
+	case float64:
+		return typedValue, true
+	case float32:
+		return float64(typedValue), true
+	case int:
+		return float64(typedValue), true
+	case int8:
+		return float64(typedValue), true
+	case int16:
+		return float64(typedValue), true
+	case int32:
+		return float64(typedValue), true
+	case int64:
+		return float64(typedValue), true
+	case uint:
+		return float64(typedValue), true
+	case uint8:
+		return float64(typedValue), true
+	case uint16:
+		return float64(typedValue), true
+	case uint32:
+		return float64(typedValue), true
+	case uint64:
+		return float64(typedValue), true
+	}
+	return 0, false
+}
+
+// orderedValueLen returns the length of the encoded value at the beginning of the buffer
+func orderedValueLen(buff []byte) int {
+	if len(buff) == 0 {
+		return 0
+	}
+
+	switch buff[0] {
+	case orderedValueBool:
+		return 2
+	case orderedValueNumber, orderedValueTime:
+		return 9
+	case orderedValueString:
+		for i := 1; i < len(buff)-1; i++ {
+			if buff[i] != 0 {
+				continue
+			}
+			if buff[i+1] == 1 {
+				return i + 2
+			}
+			// Escaped zero
+			i++
+		}
+	}
+
+	return len(buff)
+}
+
+// eachOrderedValue calls fn with every encoded value of the concatenated values
+func eachOrderedValue(values []byte, fn func(value []byte) error) error {
+	for len(values) > 0 {
+		n := orderedValueLen(values)
+		err := fn(values[:n])
+		if err != nil {
+			return err
+		}
+		values = values[n:]
+	}
+	return nil
+}
+
+// inOrderedRange checks the value of the entry is between the bounds
+func inOrderedRange(value, from, to []byte) bool {
+	if from != nil && bytes.Compare(value, from) < 0 {
+		return false
+	}
+	if to != nil && bytes.Compare(value, to) > 0 {
+		return false
+	}
+	return true
+}
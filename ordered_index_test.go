@@ -0,0 +1,517 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+type testOrder struct {
+	Customer string  `json:"customer"`
+	Total    float64 `json:"total"`
+}
+
+func TestOrderedIndex(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	var orders *Collection
+	orders, err = testDB.Use("orders")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Existing documents are indexed when the index is created
+	err = orders.Put("order 1", &testOrder{"alice", 12.5})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = orders.SetOrderedIndex("customer", FieldSelector("customer"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = orders.SetOrderedIndex("total", FieldSelector("total"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	toPut := map[string]*testOrder{
+		"order 2": {"alice", -3},
+		"order 3": {"bob", 20},
+		"order 4": {"bob", 100},
+		"order 5": {"carol", 0},
+	}
+	for id, order := range toPut {
+		err = orders.Put(id, order)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	listIDs := func(iter *IndexIterator, err error) []string {
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		defer iter.Close()
+
+		ids := []string{}
+		for ; iter.Valid(); iter.Next() {
+			order := new(testOrder)
+			err = iter.Value(order)
+			if err != nil {
+				t.Error(err)
+				return nil
+			}
+			ids = append(ids, iter.GetID())
+		}
+		return ids
+	}
+
+	check := func(expected, got []string) {
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("expected %v but got %v", expected, got)
+		}
+	}
+
+	check([]string{"order 1", "order 2"}, listIDs(orders.Lookup("customer", "alice")))
+	check([]string{"order 2", "order 5", "order 1", "order 3"}, listIDs(orders.RangeLookup("total", -10, 20)))
+	check([]string{"order 3", "order 4"}, listIDs(orders.RangeLookup("total", 15, nil)))
+	check([]string{"order 2", "order 5"}, listIDs(orders.RangeLookup("total", nil, 0)))
+
+	// Updates and deletes remove the old entries
+	err = orders.Put("order 1", &testOrder{"bob", 50})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = orders.Delete("order 2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	check([]string{}, listIDs(orders.Lookup("customer", "alice")))
+	check([]string{"order 1", "order 3", "order 4"}, listIDs(orders.Lookup("customer", "bob")))
+	check([]string{"order 5", "order 3", "order 1"}, listIDs(orders.RangeLookup("total", -10, 50)))
+
+	_, err = orders.Lookup("does not exist", "alice")
+	if err != ErrIndexNotFound {
+		t.Errorf("expected %v but got %v", ErrIndexNotFound, err)
+		return
+	}
+	_, err = orders.Lookup("customer", struct{}{})
+	if err != ErrIndexValueType {
+		t.Errorf("expected %v but got %v", ErrIndexValueType, err)
+		return
+	}
+
+	// The documents can't be written until the selectors are set after the opening
+	testDB.Close()
+	testDB, err = Open(testPath, testConfigKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	orders, err = testDB.Use("orders")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = orders.Put("order 6", &testOrder{"alice", 1})
+	if err != ErrIndexNotReady {
+		t.Errorf("expected %v but got %v", ErrIndexNotReady, err)
+		return
+	}
+	// The deletes only remove the entries
+	err = orders.Delete("order 5")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	orders.SetOrderedIndex("customer", FieldSelector("customer"))
+	orders.SetOrderedIndex("total", FieldSelector("total"))
+	err = orders.Put("order 6", &testOrder{"alice", 1})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	check([]string{"order 6"}, listIDs(orders.Lookup("customer", "alice")))
+	check([]string{"order 6", "order 3", "order 1"}, listIDs(orders.RangeLookup("total", -10, 50)))
+
+	// The deleted index has no entry left and its selector is not needed after the opening
+	totalIndex, err := orders.GetOrderedIndex("total")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = orders.DeleteOrderedIndex("total")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = orders.DeleteOrderedIndex("total")
+	if err != ErrIndexNotFound {
+		t.Errorf("expected %v but got %v", ErrIndexNotFound, err)
+		return
+	}
+	_, err = orders.RangeLookup("total", nil, nil)
+	if err != ErrIndexNotFound {
+		t.Errorf("expected %v but got %v", ErrIndexNotFound, err)
+		return
+	}
+	testDB.badger.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+		for iter.Seek(totalIndex.Prefix); iter.ValidForPrefix(totalIndex.Prefix); iter.Next() {
+			t.Errorf("the entries of the deleted index must be removed")
+			break
+		}
+		return nil
+	})
+
+	testDB.Close()
+	testDB, err = Open(testPath, testConfigKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	orders, err = testDB.Use("orders")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	orders.SetOrderedIndex("customer", FieldSelector("customer"))
+	err = orders.Put("order 7", &testOrder{"alice", 2})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestOrderedIndexConcurrentCreation(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	var orders *Collection
+	orders, err = testDB.Use("orders")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 100; i++ {
+		err = orders.Put(fmt.Sprintf("order %d", i), &testOrder{"alice", 1})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// The documents updated while the index is created must have their last value indexed
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			orders.Put(fmt.Sprintf("order %d", i), &testOrder{"bob", 2})
+		}(i)
+	}
+	err = orders.SetOrderedIndex("customer", FieldSelector("customer"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	wg.Wait()
+
+	iter, err := orders.Lookup("customer", "alice")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer iter.Close()
+	if iter.Valid() {
+		t.Errorf("the old value of %q must not be indexed", iter.GetID())
+	}
+}
+
+func TestOrderedValueEncoding(t *testing.T) {
+	ordered := []interface{}{
+		false,
+		true,
+		-1000.5,
+		-1,
+		0,
+		uint8(1),
+		1.5,
+		int64(1000),
+		time.Unix(-10, 0),
+		time.Unix(10, 0),
+		"",
+		"a",
+		"a\x00",
+		"a\x00b",
+		"ab",
+		"b",
+	}
+
+	var previous []byte
+	for _, value := range ordered {
+		encoded, err := encodeOrderedValue(value)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if orderedValueLen(append(encoded, "id"...)) != len(encoded) {
+			t.Errorf("the length of %v is not found back", value)
+			return
+		}
+		if previous != nil && bytes.Compare(previous, encoded) >= 0 {
+			t.Errorf("%v is not after the previous value", value)
+			return
+		}
+		previous = encoded
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	var users *Collection
+	users, err = testDB.Use("users")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	users.Put("user 1", &testUserStruct{"user 1", "a@internet.org", nil})
+	users.Put("user 2", &testUserStruct{"user 2", "b@internet.org", nil})
+
+	err = users.SetUniqueIndex("email", FieldSelector("email"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = users.Put("user 3", &testUserStruct{"user 3", "a@internet.org", nil})
+	if err != ErrUniqueViolation {
+		t.Errorf("expected %v but got %v", ErrUniqueViolation, err)
+		return
+	}
+	_, err = users.Get("user 3", nil)
+	if err == nil {
+		t.Errorf("the document must not be written")
+		return
+	}
+
+	// Two documents written one after the other can't have the same value
+	err = users.Put("first", &testUserStruct{"first", "first@internet.org", nil})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = users.Put("second", &testUserStruct{"second", "first@internet.org", nil})
+	if err != ErrUniqueViolation {
+		t.Errorf("expected %v but got %v", ErrUniqueViolation, err)
+		return
+	}
+	iter, err := users.Lookup("email", "first@internet.org")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ids := []string{}
+	for ; iter.Valid(); iter.Next() {
+		ids = append(ids, iter.GetID())
+	}
+	iter.Close()
+	if !reflect.DeepEqual(ids, []string{"first"}) {
+		t.Errorf("expected only the first document in the index but got %v", ids)
+		return
+	}
+
+	// The same document can keep its value
+	err = users.Put("user 1", &testUserStruct{"user 1 updated", "a@internet.org", nil})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Nothing of the batch is written
+	b, _ := users.NewBatch(context.Background())
+	b.Put("user 4", &testUserStruct{"user 4", "c@internet.org", nil})
+	b.Put("user 5", &testUserStruct{"user 5", "b@internet.org", nil})
+	err = b.Write()
+	if err != ErrUniqueViolation {
+		t.Errorf("expected %v but got %v", ErrUniqueViolation, err)
+		return
+	}
+	_, err = users.Get("user 4", nil)
+	if err == nil {
+		t.Errorf("the document of the failed batch must not be written")
+		return
+	}
+
+	// The value is free once the document is deleted
+	err = users.Delete("user 1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = users.Put("user 3", &testUserStruct{"user 3", "a@internet.org", nil})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Only one of the concurrent writes can have the value
+	var wg sync.WaitGroup
+	var succeeded int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := users.Put(fmt.Sprintf("racer %d", i), &testUserStruct{"racer", "race@internet.org", nil})
+			if err == nil {
+				atomic.AddInt32(&succeeded, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if succeeded != 1 {
+		t.Errorf("expected 1 write but got %d", succeeded)
+		return
+	}
+
+	// A transaction can't take a value saved by an other write since its beginning
+	err = testDB.Update(func(tx *Tx) error {
+		col, err := tx.Collection("users")
+		if err != nil {
+			return err
+		}
+
+		err = users.Put("outside", &testUserStruct{"outside", "tx@internet.org", nil})
+		if err != nil {
+			return err
+		}
+
+		return col.Put("inside", &testUserStruct{"inside", "tx@internet.org", nil})
+	})
+	if err != ErrConflict {
+		t.Errorf("expected %v but got %v", ErrConflict, err)
+		return
+	}
+	_, err = users.Get("inside", nil)
+	if err == nil {
+		t.Errorf("the document of the transaction must not be written")
+		return
+	}
+
+	// Two documents of the same transaction can't have the same value
+	err = testDB.Update(func(tx *Tx) error {
+		col, err := tx.Collection("users")
+		if err != nil {
+			return err
+		}
+
+		err = col.Put("tx 1", &testUserStruct{"tx 1", "same@internet.org", nil})
+		if err != nil {
+			return err
+		}
+		return col.Put("tx 2", &testUserStruct{"tx 2", "same@internet.org", nil})
+	})
+	if err != ErrUniqueViolation {
+		t.Errorf("expected %v but got %v", ErrUniqueViolation, err)
+		return
+	}
+
+	// The existing documents must respect the constraint
+	err = users.Put("user 6", &testUserStruct{"user 2", "f@internet.org", nil})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = users.SetUniqueIndex("name", FieldSelector("name"))
+	if err != ErrUniqueViolation {
+		t.Errorf("expected %v but got %v", ErrUniqueViolation, err)
+		return
+	}
+	_, err = users.GetOrderedIndex("name")
+	if err != ErrIndexNotFound {
+		t.Errorf("the index must not be added")
+		return
+	}
+}
+
+func TestOrderedIndexAddedAfterBatch(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	var orders *Collection
+	orders, err = testDB.Use("orders")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The batch is built before the collection has any index
+	batch, err := orders.NewBatch(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = batch.Put("order 1", &testOrder{"alice", 12.5})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = orders.SetOrderedIndex("customer", FieldSelector("customer"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = batch.Write()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	iter, err := orders.Lookup("customer", "alice")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer iter.Close()
+	if !iter.Valid() || iter.GetID() != "order 1" {
+		t.Errorf("the document written after the creation of the index must be indexed")
+	}
+}
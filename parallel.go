@@ -0,0 +1,164 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+)
+
+// parallelScanSamples is the number of keys sampled by worker to split the collection
+const parallelScanSamples = 64
+
+// ForEachParallel calls fn for every document of the collection with the content as bytes.
+// The collection is split into key ranges of about the same number of documents and every
+// worker reads, decrypts and decompresses the documents of its range. If workers is 0 or less
+// the number of CPU is used. Every range is read from its own snapshot.
+//
+// fn is called concurrently and in no particular order. The scan stops on the
+// first error returned by fn or when the context is done and this error is returned.
+func (c *Collection) ForEachParallel(ctx context.Context, workers int, fn func(id string, raw []byte) error) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	scanCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var firstErr error
+	var errOnce sync.Once
+	setErr := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	prefix := c.buildDBKey("")
+	starts, err := c.splitKeyRanges(prefix, workers)
+	if err != nil {
+		return err
+	}
+
+	wg := sync.WaitGroup{}
+	for i, start := range starts {
+		// The last range goes to the end of the collection
+		var end []byte
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+
+		wg.Add(1)
+		go func(start, end []byte) {
+			defer wg.Done()
+
+			err := c.forEachInRange(scanCtx, prefix, start, end, fn)
+			if err != nil {
+				setErr(err)
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// forEachInRange calls fn for the documents from start to end, end excluded.
+// If end is nil it goes to the end of the collection.
+func (c *Collection) forEachInRange(ctx context.Context, prefix, start, end []byte, fn func(id string, raw []byte) error) error {
+	return c.db.badger.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		for iter.Seek(start); iter.ValidForPrefix(prefix); iter.Next() {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			item := iter.Item()
+			if end != nil && bytes.Compare(item.Key(), end) >= 0 {
+				return nil
+			}
+			if item.IsDeletedOrExpired() {
+				continue
+			}
+
+			caller := new(multiGetCaller)
+			caller.dbID = item.KeyCopy(nil)
+			caller.id = string(caller.dbID[len(prefix):])
+
+			var err error
+			caller.encryptedAsBytes, err = item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			caller.userMeta = item.UserMeta()
+
+			err = c.decryptAndUnmarshal(caller)
+			if err != nil {
+				return err
+			}
+			err = fn(caller.id, caller.asBytes)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// splitKeyRanges returns the first keys of at most n ranges of the collection with about
+// the same number of documents. The first one is the prefix of the collection.
+// The keys are sampled without reading the values, the sampling step doubles every time
+// the samples are full to keep the memory bounded.
+func (c *Collection) splitKeyRanges(prefix []byte, n int) ([][]byte, error) {
+	samples := [][]byte{}
+	step := 1
+
+	err := c.db.badger.View(func(txn *badger.Txn) error {
+		opt := badger.DefaultIteratorOptions
+		opt.PrefetchValues = false
+		iter := txn.NewIterator(opt)
+		defer iter.Close()
+
+		i := 0
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			if iter.Item().IsDeletedOrExpired() {
+				continue
+			}
+
+			if i%step == 0 {
+				samples = append(samples, iter.Item().KeyCopy(nil))
+				if len(samples) == 2*n*parallelScanSamples {
+					// Keeps one sample out of two
+					for j := 0; j < len(samples)/2; j++ {
+						samples[j] = samples[2*j]
+					}
+					samples = samples[:len(samples)/2]
+					step *= 2
+				}
+			}
+			i++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	starts := [][]byte{prefix}
+	for i := 1; i < n; i++ {
+		sample := i * len(samples) / n
+		if sample == 0 || bytes.Equal(samples[sample], starts[len(starts)-1]) {
+			continue
+		}
+		starts = append(starts, samples[sample])
+	}
+
+	return starts, nil
+}
@@ -0,0 +1,106 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestForEachParallel(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	var col *Collection
+	col, err = testDB.Use("parallel")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	nbDocs := 500
+	for i := 0; i < nbDocs; i++ {
+		id := fmt.Sprintf("id %03d", i)
+		err = col.Put(id, &testUserStruct{Name: id})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// The collection is split into ranges of the same size
+	prefix := col.buildDBKey("")
+	starts, err := col.splitKeyRanges(prefix, 4)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(starts) != 4 || !bytes.Equal(starts[0], prefix) {
+		t.Errorf("expected 4 ranges starting with the prefix but got %d", len(starts))
+		return
+	}
+	for i, start := range starts[1:] {
+		expected := col.buildDBKey(fmt.Sprintf("id %03d", (i+1)*nbDocs/4))
+		if !bytes.Equal(start, expected) {
+			t.Errorf("expected the range %d to start at %q but got %q", i+1, expected, start)
+			return
+		}
+	}
+
+	seen := map[string]bool{}
+	lock := sync.Mutex{}
+	err = col.ForEachParallel(context.Background(), 4, func(id string, raw []byte) error {
+		user := new(testUserStruct)
+		err := json.Unmarshal(raw, user)
+		if err != nil {
+			return err
+		}
+		if user.Name != id {
+			return fmt.Errorf("expected %q but got %q", id, user.Name)
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		if seen[id] {
+			return fmt.Errorf("the document %q is given twice", id)
+		}
+		seen[id] = true
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(seen) != nbDocs {
+		t.Errorf("expected %d documents but got %d", nbDocs, len(seen))
+		return
+	}
+
+	// The first error stops the scan
+	stopErr := fmt.Errorf("stop")
+	err = col.ForEachParallel(context.Background(), 4, func(id string, raw []byte) error {
+		if id == "id 250" {
+			return stopErr
+		}
+		return nil
+	})
+	if err != stopErr {
+		t.Errorf("expected %v but got %v", stopErr, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = col.ForEachParallel(ctx, 0, func(id string, raw []byte) error {
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("expected %v but got %v", context.Canceled, err)
+		return
+	}
+}
@@ -0,0 +1,508 @@
+package gotinydb
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/alexandrestein/gotinydb/transaction"
+	"github.com/dgraph-io/badger"
+)
+
+type (
+	// Ref is a reference to a document of a collection. It can be embedded in the documents
+	// and is resolved by *Collection.GetWithRefs.
+	Ref struct {
+		Collection string `json:"collection"`
+		ID         string `json:"id"`
+
+		// Content is the referenced document as it is saved, it's set by *Collection.GetWithRefs
+		Content []byte `json:"-"`
+		// Value is filled up with the referenced document by *Collection.GetWithRefs.
+		// If it's nil it is set with the document decoded by the ToIndex function of the codec
+		// of the referenced collection, otherwise it must be a pointer.
+		Value interface{} `json:"-"`
+	}
+)
+
+// Those constants separate the entries, saved by referenced document, from the lists of
+// the entries of the referencing documents
+const (
+	refsEntries byte = iota
+	refsReferences
+)
+
+var refType = reflect.TypeOf(Ref{})
+
+// NewRef returns a reference to the document with the given id in the collection
+func NewRef(collection, id string) Ref {
+	return Ref{
+		Collection: collection,
+		ID:         id,
+	}
+}
+
+// GetWithRefs does the same as *Collection.Get and resolves the references of the document.
+// The Ref values found in dest are filled up with the referenced documents, then the references
+// of those documents are resolved up to the given depth. A depth of 1 only resolves the
+// references of the document.
+// The references to missing documents are left empty.
+func (c *Collection) GetWithRefs(id string, dest interface{}, depth int) ([]byte, error) {
+	contentAsBytes, err := c.Get(id, dest)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []interface{}{dest}
+	for ; depth > 0 && len(values) != 0; depth-- {
+		refs := []*Ref{}
+		visited := map[uintptr]bool{}
+		for _, value := range values {
+			collectRefs(reflect.ValueOf(value), &refs, visited)
+		}
+
+		values, err = c.db.resolveRefs(refs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return contentAsBytes, nil
+}
+
+// resolveRefs loads the referenced documents with one *Collection.GetMulti by collection.
+// It returns the values of the resolved references.
+func (d *DB) resolveRefs(refs []*Ref) ([]interface{}, error) {
+	byCollection := map[string][]*Ref{}
+	for _, ref := range refs {
+		// The empty references can't be resolved
+		if ref.ID == "" {
+			continue
+		}
+		byCollection[ref.Collection] = append(byCollection[ref.Collection], ref)
+	}
+
+	ret := []interface{}{}
+	for colName, colRefs := range byCollection {
+		col, err := d.getCollection(colName)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		// The same document can be referenced many times
+		positions := map[string]int{}
+		ids := []string{}
+		for _, ref := range colRefs {
+			if _, found := positions[ref.ID]; !found {
+				positions[ref.ID] = len(ids)
+				ids = append(ids, ref.ID)
+			}
+		}
+
+		contents, err := col.GetMulti(ids, make([]interface{}, len(ids)))
+		if err != nil {
+			// Some documents are missing, they are loaded one by one
+			contents = make([][]byte, len(ids))
+			for i, id := range ids {
+				contents[i], err = col.Get(id, nil)
+				if err != nil && err != badger.ErrKeyNotFound {
+					return nil, err
+				}
+			}
+		}
+
+		codec, err := col.getCodec()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ref := range colRefs {
+			content := contents[positions[ref.ID]]
+			if content == nil {
+				continue
+			}
+
+			ref.Content = content
+			if ref.Value == nil {
+				ref.Value, err = codec.ToIndex(content)
+			} else {
+				err = codec.Unmarshal(content, ref.Value)
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			ret = append(ret, ref.Value)
+		}
+	}
+
+	return ret, nil
+}
+
+// collectRefs adds the addressable Ref values found in the value to the list
+func collectRefs(value reflect.Value, refs *[]*Ref, visited map[uintptr]bool) {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() || visited[value.Pointer()] {
+			return
+		}
+		visited[value.Pointer()] = true
+		collectRefs(value.Elem(), refs, visited)
+	case reflect.Interface:
+		if !value.IsNil() {
+			collectRefs(value.Elem(), refs, visited)
+		}
+	case reflect.Struct:
+		if value.Type() == refType {
+			if value.CanAddr() {
+				*refs = append(*refs, value.Addr().Interface().(*Ref))
+			}
+			return
+		}
+		for i := 0; i < value.NumField(); i++ {
+			// Not exported
+			if value.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			collectRefs(value.Field(i), refs, visited)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			collectRefs(value.Index(i), refs, visited)
+		}
+	case reflect.Map:
+		// The values of a map are not addressable, only the pointers can be resolved
+		for _, key := range value.MapKeys() {
+			collectRefs(value.MapIndex(key), refs, visited)
+		}
+	}
+}
+
+// SetRefIntegrity defines if the references saved in the documents of the collection
+// protect the referenced documents. If it's enabled the deletes of referenced documents
+// fail with ErrReferenced.
+// The references are found in the documents decoded with the ToIndex function of the codec,
+// they are the objects with only a collection and an id, like Ref is saved. Any other object
+// of the documents with only those two string fields is also taken as a reference.
+func (c *Collection) SetRefIntegrity(enabled bool) error {
+	if c.RefIntegrity == enabled {
+		return nil
+	}
+
+	var err error
+	if enabled {
+		err = c.saveExistingRefs()
+	} else {
+		// The hooks stop saving the references before the existing ones are removed
+		c.db.commitLock.Lock()
+		c.RefIntegrity = false
+		c.db.commitLock.Unlock()
+
+		err = c.clearRefs()
+		if err != nil {
+			c.db.commitLock.Lock()
+			c.RefIntegrity = true
+			c.db.commitLock.Unlock()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.db.saveConfig()
+}
+
+func (d *DB) hasRefIntegrity() bool {
+	for _, col := range d.Collections {
+		if col.RefIntegrity {
+			return true
+		}
+	}
+	return false
+}
+
+// buildRefsHook returns the function which saves the references of the document and,
+// for the deletes, the function which checks the document is not referenced anymore
+// once the transaction is written
+func (c *Collection) buildRefsHook(id string, document *indexedDocument, delete bool) (hook, check func(txn *badger.Txn) error, _ error) {
+	if delete && c.db.hasRefIntegrity() {
+		check = func(txn *badger.Txn) error {
+			return checkNotReferenced(txn, c.Name, id)
+		}
+	}
+
+	// The setting is read by the hook because it's changed while the writes wait
+	hook = func(txn *badger.Txn) error {
+		if !c.RefIntegrity {
+			return nil
+		}
+
+		// Like for the indexes the documents which can't be decoded have no reference
+		decoded, err := document.get()
+		if err != nil {
+			return err
+		}
+		entries, err := buildRefsEntries(c.Name, id, decoded)
+		if err != nil {
+			return err
+		}
+
+		return c.db.updateRefs(txn, c.Name, id, entries)
+	}
+
+	return hook, check, nil
+}
+
+// checkNotReferenced returns ErrReferenced if a document references the given one
+func checkNotReferenced(txn *badger.Txn, colName, id string) error {
+	prefix, err := buildRefsEntryPrefix(colName, id)
+	if err != nil {
+		return err
+	}
+
+	opt := badger.DefaultIteratorOptions
+	opt.PrefetchValues = false
+	iter := txn.NewIterator(opt)
+	defer iter.Close()
+
+	for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+		if !iter.Item().IsDeletedOrExpired() {
+			return ErrReferenced
+		}
+	}
+	return nil
+}
+
+// deleteRefsTo removes the entries of the references to the documents of the collection.
+// The lists of the referencing documents keep the keys, they are ignored once deleted.
+func (d *DB) deleteRefsTo(colName string) error {
+	encodedColName, err := encodeOrderedValue(colName)
+	if err != nil {
+		return err
+	}
+
+	d.deletePrefix(append([]byte{prefixRefs, refsEntries}, encodedColName...))
+	return nil
+}
+
+// updateRefs removes the old entries of the document and saves the new ones
+func (d *DB) updateRefs(txn *badger.Txn, colName, id string, entries [][]byte) error {
+	listKey, err := buildRefsListKey(colName, id)
+	if err != nil {
+		return err
+	}
+
+	item, err := txn.Get(listKey)
+	if err == nil {
+		var oldEntries []byte
+		oldEntries, err = d.decryptItem(item)
+		if err != nil {
+			return err
+		}
+
+		var entryKeys [][]byte
+		err = json.Unmarshal(oldEntries, &entryKeys)
+		if err != nil {
+			return err
+		}
+
+		for _, entryKey := range entryKeys {
+			err = txn.Delete(entryKey)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(entries) == 0 {
+			return txn.Delete(listKey)
+		}
+	} else if err != badger.ErrKeyNotFound {
+		return err
+	}
+
+	ops, err := buildRefsOperations(listKey, id, entries)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		err = d.writeOperation(txn, op)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveExistingRefs enables the references and saves the ones of the documents already in the collection.
+// The other writes wait until it's done.
+func (c *Collection) saveExistingRefs() error {
+	add := func() {
+		c.RefIntegrity = true
+	}
+	remove := func() {
+		c.RefIntegrity = false
+	}
+
+	return c.backfill(add, remove, func(id string, document interface{}) ([]*transaction.Operation, error) {
+		entries, err := buildRefsEntries(c.Name, id, document)
+		if err != nil {
+			return nil, err
+		}
+
+		listKey, err := buildRefsListKey(c.Name, id)
+		if err != nil {
+			return nil, err
+		}
+
+		return buildRefsOperations(listKey, id, entries)
+	})
+}
+
+// clearRefs removes the references saved for the documents of the collection
+func (c *Collection) clearRefs() error {
+	prefix, err := buildRefsListKey(c.Name, "")
+	if err != nil {
+		return err
+	}
+
+	ops := []*transaction.Operation{}
+	err = c.db.badger.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			item := iter.Item()
+
+			clearBytes, err := c.db.decryptItem(item)
+			if err != nil {
+				return err
+			}
+
+			var entryKeys [][]byte
+			err = json.Unmarshal(clearBytes, &entryKeys)
+			if err != nil {
+				return err
+			}
+
+			id := string(item.Key()[len(prefix):])
+			for _, entryKey := range entryKeys {
+				ops = append(ops, transaction.NewOperation(id, nil, entryKey, nil, true, true))
+			}
+			ops = append(ops, transaction.NewOperation(id, nil, item.KeyCopy(nil), nil, true, true))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.writeOperationsByBatches(ops)
+}
+
+// buildRefsEntries returns the keys of the entries of the references found in the document
+func buildRefsEntries(colName, id string, document interface{}) ([][]byte, error) {
+	ret := [][]byte{}
+	var err error
+	findRefs(document, func(ref Ref) {
+		if err != nil {
+			return
+		}
+
+		var key []byte
+		key, err = buildRefsEntryPrefix(ref.Collection, ref.ID)
+		if err != nil {
+			return
+		}
+
+		var encodedColName []byte
+		encodedColName, err = encodeOrderedValue(colName)
+		if err != nil {
+			return
+		}
+
+		key = append(key, encodedColName...)
+		ret = append(ret, append(key, id...))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// findRefs calls fn for every object of the decoded document which is a saved Ref
+func findRefs(document interface{}, fn func(ref Ref)) {
+	switch typedDocument := document.(type) {
+	case map[string]interface{}:
+		if len(typedDocument) == 2 {
+			colName, colOk := typedDocument["collection"].(string)
+			id, idOk := typedDocument["id"].(string)
+			if colOk && idOk {
+				// The empty references are not saved
+				if id != "" {
+					fn(NewRef(colName, id))
+				}
+				return
+			}
+		}
+		for _, value := range typedDocument {
+			findRefs(value, fn)
+		}
+	case []interface{}:
+		for _, value := range typedDocument {
+			findRefs(value, fn)
+		}
+	}
+}
+
+// buildRefsOperations returns the operations which save the entries and the list
+// of the entries of the document to find them back
+func buildRefsOperations(listKey []byte, id string, entries [][]byte) ([]*transaction.Operation, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	listValue, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []*transaction.Operation{
+		transaction.NewOperation(id, nil, listKey, listValue, false, true),
+	}
+	for _, entry := range entries {
+		ops = append(ops, transaction.NewOperation(id, nil, entry, []byte{}, false, true))
+	}
+	return ops, nil
+}
+
+// buildRefsEntryPrefix returns the prefix of the entries of the documents referencing
+// the given one. The names and the ids are encoded to not be ambiguous.
+func buildRefsEntryPrefix(colName, id string) ([]byte, error) {
+	encodedColName, err := encodeOrderedValue(colName)
+	if err != nil {
+		return nil, err
+	}
+	encodedID, err := encodeOrderedValue(id)
+	if err != nil {
+		return nil, err
+	}
+
+	key := []byte{prefixRefs, refsEntries}
+	key = append(key, encodedColName...)
+	return append(key, encodedID...), nil
+}
+
+// buildRefsListKey returns the key of the list of the entries of the document
+func buildRefsListKey(colName, id string) ([]byte, error) {
+	encodedColName, err := encodeOrderedValue(colName)
+	if err != nil {
+		return nil, err
+	}
+
+	key := []byte{prefixRefs, refsReferences}
+	key = append(key, encodedColName...)
+	return append(key, id...), nil
+}
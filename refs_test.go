@@ -0,0 +1,193 @@
+package gotinydb
+
+import (
+	"testing"
+	"time"
+)
+
+type (
+	testRefCompany struct {
+		Name string `json:"name"`
+	}
+	testRefCustomer struct {
+		Name    string `json:"name"`
+		Company Ref    `json:"company"`
+	}
+	testRefOrder struct {
+		Customer Ref   `json:"customer"`
+		Products []Ref `json:"products"`
+	}
+)
+
+func TestRefs(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	cols := map[string]*Collection{}
+	for _, name := range []string{"companies", "customers", "orders"} {
+		cols[name], err = testDB.Use(name)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	err = cols["companies"].Put("acme", &testRefCompany{Name: "ACME"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = cols["customers"].Put("bob", &testRefCustomer{Name: "Bob", Company: NewRef("companies", "acme")})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = cols["orders"].Put("order 1", &testRefOrder{
+		Customer: NewRef("customers", "bob"),
+		Products: []Ref{NewRef(testColName, testUserID), NewRef(testColName, "does not exist")},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The typed values are filled up and the nested references are resolved
+	customer := new(testRefCustomer)
+	order := &testRefOrder{Customer: Ref{Value: customer}}
+	_, err = cols["orders"].GetWithRefs("order 1", order, 2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if customer.Name != "Bob" {
+		t.Errorf("expected the customer to be resolved but got %v", customer)
+		return
+	}
+	if company, ok := customer.Company.Value.(map[string]interface{}); !ok || company["name"] != "ACME" {
+		t.Errorf("expected the company to be resolved but got %v", customer.Company.Value)
+		return
+	}
+	if user, ok := order.Products[0].Value.(map[string]interface{}); !ok || user["name"] != testUser.Name {
+		t.Errorf("expected the product to be resolved but got %v", order.Products[0].Value)
+		return
+	}
+	if order.Products[1].Value != nil || order.Products[1].Content != nil {
+		t.Errorf("the missing document must not be resolved")
+		return
+	}
+
+	// Only the first level is resolved
+	customer = new(testRefCustomer)
+	_, err = cols["orders"].GetWithRefs("order 1", &testRefOrder{Customer: Ref{Value: customer}}, 1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if customer.Name != "Bob" || customer.Company.Value != nil {
+		t.Errorf("expected only the customer to be resolved but got %v", customer)
+		return
+	}
+
+	// The existing references protect the documents once the integrity is enabled
+	err = cols["orders"].SetRefIntegrity(true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = cols["customers"].SetRefIntegrity(true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = cols["customers"].Delete("bob")
+	if err != ErrReferenced {
+		t.Errorf("expected %v but got %v", ErrReferenced, err)
+		return
+	}
+	if _, err = cols["customers"].Get("bob", nil); err != nil {
+		t.Errorf("the referenced document must not be deleted: %v", err)
+		return
+	}
+
+	// The reference is removed when the document changes
+	err = cols["orders"].Put("order 1", &testRefOrder{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = cols["customers"].Delete("bob")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The company is not referenced anymore once the customer is deleted
+	err = cols["companies"].Delete("acme")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The references of a collection without integrity don't protect the documents
+	err = cols["orders"].SetRefIntegrity(false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = cols["orders"].Put("order 2", &testRefOrder{Customer: NewRef(testColName, testUserID)})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = testCol.Delete(testUserID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The empty references are left empty
+	order = &testRefOrder{}
+	err = cols["orders"].Put("order 3", &testRefOrder{Customer: NewRef("customers", "")})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = cols["orders"].GetWithRefs("order 3", order, 1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if order.Customer.Value != nil {
+		t.Errorf("the empty reference must not be resolved")
+		return
+	}
+
+	// The references to a deleted collection don't protect the documents of a new one
+	err = cols["customers"].Put("alice", &testRefCustomer{Name: "Alice", Company: NewRef("companies", "acme")})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	testDB.DeleteCollection("companies")
+	time.Sleep(time.Second)
+
+	cols["companies"], err = testDB.Use("companies")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = cols["companies"].Put("acme", &testRefCompany{Name: "ACME"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = cols["companies"].Delete("acme")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,168 @@
+package gotinydb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+type (
+	// ValidationError is returned when a document does not match the JSON Schema
+	// of the collection. It lists all the failing paths of the document.
+	ValidationError struct {
+		ID       string
+		Failures []*ValidationFailure
+	}
+
+	// ValidationFailure defines one failing path of a document
+	ValidationFailure struct {
+		// Path is the path of the failing field, "(root)" is the document itself
+		Path        string
+		Description string
+	}
+)
+
+func (e *ValidationError) Error() string {
+	failures := make([]string, len(e.Failures))
+	for i, failure := range e.Failures {
+		failures[i] = fmt.Sprintf("%s: %s", failure.Path, failure.Description)
+	}
+	return fmt.Sprintf("document %q does not match the collection schema: %s", e.ID, strings.Join(failures, ", "))
+}
+
+// SetSchema defines the JSON Schema all documents of the collection must match.
+// Every Put and Batch.Put are checked and a *ValidationError is returned if the
+// document does not match. A nil schema removes the validation.
+// The schema is saved with the database configuration.
+//
+// Existing documents are not checked, use *Collection.CheckSchema to find them.
+func (c *Collection) SetSchema(jsonSchema []byte) (err error) {
+	var schema *gojsonschema.Schema
+	if jsonSchema != nil {
+		schema, err = gojsonschema.NewSchema(gojsonschema.NewBytesLoader(jsonSchema))
+		if err != nil {
+			return err
+		}
+	}
+
+	c.Schema = jsonSchema
+	c.schema = schema
+
+	return c.db.saveConfig()
+}
+
+// CheckSchema validates all existing documents against the given JSON Schema.
+// If jsonSchema is nil the schema of the collection is used.
+// It returns the validation errors of the documents which do not match.
+func (c *Collection) CheckSchema(jsonSchema []byte) (_ []*ValidationError, err error) {
+	schema := c.schema
+	if jsonSchema != nil {
+		schema, err = gojsonschema.NewSchema(gojsonschema.NewBytesLoader(jsonSchema))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if schema == nil {
+		return nil, nil
+	}
+
+	ret := []*ValidationError{}
+
+	iter := c.GetIterator()
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		var loader gojsonschema.JSONLoader
+		loader, err = c.documentLoader(iter.GetBytes())
+		if err != nil {
+			return nil, err
+		}
+
+		validationErr := validateDocument(schema, iter.GetID(), loader)
+		if validationErr != nil {
+			ret = append(ret, validationErr)
+		}
+	}
+
+	return ret, nil
+}
+
+// loadSchema builds the schema validator from the saved configuration
+func (c *Collection) loadSchema() (err error) {
+	if c.Schema == nil {
+		return nil
+	}
+
+	c.schema, err = gojsonschema.NewSchema(gojsonschema.NewBytesLoader(c.Schema))
+	return
+}
+
+// validate checks the document against the collection schema if any
+func (c *Collection) validate(id string, document []byte) error {
+	if c.schema == nil {
+		return nil
+	}
+
+	loader, err := c.documentLoader(document)
+	if err != nil {
+		return err
+	}
+
+	validationErr := validateDocument(c.schema, id, loader)
+	if validationErr != nil {
+		return validationErr
+	}
+	return nil
+}
+
+// documentLoader returns the loader used to validate the saved bytes.
+// If the collection does not use JSON the document is decoded with the collection codec.
+func (c *Collection) documentLoader(document []byte) (gojsonschema.JSONLoader, error) {
+	codec, err := c.getCodec()
+	if err != nil {
+		return nil, err
+	}
+
+	if codec == JSONCodec {
+		return gojsonschema.NewBytesLoader(document), nil
+	}
+
+	content, err := codec.ToIndex(document)
+	if err != nil {
+		// The document can't be decoded and the validation fails
+		return gojsonschema.NewBytesLoader(document), nil
+	}
+
+	return gojsonschema.NewGoLoader(content), nil
+}
+
+func validateDocument(schema *gojsonschema.Schema, id string, loader gojsonschema.JSONLoader) *ValidationError {
+	result, err := schema.Validate(loader)
+	if err != nil {
+		// The document is not a valid JSON document
+		return &ValidationError{
+			ID: id,
+			Failures: []*ValidationFailure{
+				{Path: "(root)", Description: err.Error()},
+			},
+		}
+	}
+
+	if result.Valid() {
+		return nil
+	}
+
+	ret := &ValidationError{
+		ID:       id,
+		Failures: make([]*ValidationFailure, len(result.Errors())),
+	}
+	for i, resultErr := range result.Errors() {
+		ret.Failures[i] = &ValidationFailure{
+			Path:        resultErr.Field(),
+			Description: resultErr.Description(),
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,112 @@
+package gotinydb
+
+import (
+	"testing"
+)
+
+var testSchema = []byte(`{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"email": {"type": "string", "pattern": "^[^@]+@[^@]+$"}
+	},
+	"required": ["name", "email"]
+}`)
+
+func TestSchema(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	// Save a document which will not match the schema before setting it
+	err = testCol.Put("old document", map[string]interface{}{"name": "old"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = testCol.SetSchema([]byte("not a schema"))
+	if err == nil {
+		t.Errorf("a wrong schema must returns an error")
+		return
+	}
+
+	err = testCol.SetSchema(testSchema)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = testCol.Put("valid", &testUserStruct{Name: "valid", Email: "valid@internet.org"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = testCol.Put("invalid", &testUserStruct{Name: "", Email: "not an email"})
+	validationErr, ok := err.(*ValidationError)
+	if !ok {
+		t.Errorf("the error must be a validation error but got %v", err)
+		return
+	}
+	if l := len(validationErr.Failures); l != 2 {
+		t.Errorf("expected 2 failures but got %d: %s", l, validationErr.Error())
+		return
+	}
+
+	batch, _ := testCol.NewBatch(testDB.ctx)
+	err = batch.Put("raw invalid", []byte("not JSON"))
+	if _, ok := err.(*ValidationError); !ok {
+		t.Errorf("the error must be a validation error but got %v", err)
+		return
+	}
+
+	var violations []*ValidationError
+	violations, err = testCol.CheckSchema(nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(violations) != 1 || violations[0].ID != "old document" {
+		t.Errorf("expected only the old document as violation but got %v", violations)
+		return
+	}
+
+	// The schema must be loaded after a restart
+	err = testDB.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	testDB, err = Open(testPath, testConfigKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	testCol, err = testDB.Use(testColName)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = testCol.Put("invalid", map[string]interface{}{"email": 10})
+	if _, ok := err.(*ValidationError); !ok {
+		t.Errorf("the error must be a validation error but got %v", err)
+		return
+	}
+
+	err = testCol.SetSchema(nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = testCol.Put("invalid", map[string]interface{}{"email": 10})
+	if err != nil {
+		t.Errorf("without schema the document must be saved but got %v", err)
+		return
+	}
+}
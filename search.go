@@ -3,6 +3,7 @@ package gotinydb
 import (
 	"github.com/blevesearch/bleve"
 	"github.com/blevesearch/bleve/search"
+	"github.com/dgraph-io/badger"
 )
 
 type (
@@ -13,6 +14,91 @@ type (
 
 		position int
 		c        *Collection
+		// collections resolves the hits to their collection by the name of the bleve index
+		// when the search is done across many collections
+		collections map[string]*Collection
+	}
+
+	// SearchOptions defines the options of *Collection.SearchString
+	SearchOptions struct {
+		// Size is the number of hits returned, 10 if not set
+		Size int
+		// From is the number of hits to skip
+		From int
+		// Sort lists the fields to sort the hits, a "-" before the field reverses the order.
+		// "_id" and "_score" sort by id and by score.
+		Sort []string
+		// Fields lists the stored fields returned with the hits
+		Fields []string
+		// Facets lists the fields to count the terms of with the number of terms returned
+		Facets map[string]int
+		// Highlight is the style of the highlighted fragments, "html" or "ansi".
+		// Nothing is highlighted if empty.
+		Highlight string
+	}
+
+	// Hit is a simple view of a search hit
+	Hit struct {
+		ID    string
+		Score float64
+		// Fields holds the stored fields requested with SearchOptions.Fields
+		Fields map[string]interface{}
+		// Fragments holds the highlighted fragments by field
+		Fragments map[string][]string
+	}
+
+	// Facet is the count of the terms of a field
+	Facet struct {
+		Field string
+		// Total is the number of terms counted
+		Total int
+		// Missing is the number of documents without the field
+		Missing int
+		// Other is the number of terms not returned
+		Other int
+		Terms []*FacetTerm
+	}
+
+	// FacetTerm is a term of a facet with the number of documents having it
+	FacetTerm struct {
+		Term  string
+		Count int
+	}
+
+	// IndexRef references an index of a collection for *DB.SearchAcross
+	IndexRef struct {
+		Collection string
+		Index      string
+	}
+
+	// SearchRequest adds options to the bleve search request.
+	// It is used by *Collection.SearchWithRequest.
+	SearchRequest struct {
+		*bleve.SearchRequest
+
+		// WaitIndexed makes the search wait until the asynchronous indexes are done with
+		// the writes returned before the search started
+		WaitIndexed bool
+	}
+
+	// SearchIterator goes through all the results of a search page by page.
+	// It is returned by *Collection.SearchIterator.
+	// The documents of every page are loaded at once when the page is fetched.
+	SearchIterator struct {
+		c       *Collection
+		index   *BleveIndex
+		request *bleve.SearchRequest
+
+		// total is the number of matching documents
+		total uint64
+		// from is the position of the next page
+		from int
+		// lastPage is true when there is no more page to fetch
+		lastPage bool
+
+		hits     search.DocumentMatchCollection
+		contents [][]byte
+		position int
 	}
 
 	// Response are returned by *SearchResult.NextResponse if the caller needs to
@@ -21,6 +107,8 @@ type (
 		ID            string
 		Content       []byte
 		DocumentMatch *search.DocumentMatch
+		// Collection is the name of the collection the document belongs to
+		Collection string
 	}
 )
 
@@ -43,6 +131,7 @@ func (s *SearchResult) NextResponse(dest interface{}) (resp *Response, _ error)
 	resp.ID = id
 	resp.Content = content
 	resp.DocumentMatch = docMatch
+	resp.Collection = s.collection(docMatch).Name
 	return resp, err
 }
 
@@ -53,9 +142,250 @@ func (s *SearchResult) next(dest interface{}) (id string, content []byte, docMat
 
 	docMatch = s.BleveSearchResult.Hits[s.position]
 	id = docMatch.ID
-	content, err = s.c.Get(id, dest)
-
 	s.position++
 
+	col := s.collection(docMatch)
+	if col == nil {
+		return id, nil, docMatch, ErrCollectionNotFound
+	}
+	content, err = col.Get(id, dest)
+
 	return
 }
+
+// Hits returns the hits of the search
+func (s *SearchResult) Hits() []*Hit {
+	ret := make([]*Hit, len(s.BleveSearchResult.Hits))
+	for i, docMatch := range s.BleveSearchResult.Hits {
+		ret[i] = &Hit{
+			ID:        docMatch.ID,
+			Score:     docMatch.Score,
+			Fields:    docMatch.Fields,
+			Fragments: docMatch.Fragments,
+		}
+	}
+	return ret
+}
+
+// Facets returns the facets requested with SearchOptions.Facets by field
+func (s *SearchResult) Facets() map[string]*Facet {
+	ret := map[string]*Facet{}
+	for name, facetResult := range s.BleveSearchResult.Facets {
+		facet := &Facet{
+			Field:   facetResult.Field,
+			Total:   facetResult.Total,
+			Missing: facetResult.Missing,
+			Other:   facetResult.Other,
+			Terms:   make([]*FacetTerm, len(facetResult.Terms)),
+		}
+		for i, term := range facetResult.Terms {
+			facet.Terms[i] = &FacetTerm{
+				Term:  term.Term,
+				Count: term.Count,
+			}
+		}
+		ret[name] = facet
+	}
+	return ret
+}
+
+// SearchString makes a search with the bleve query string syntax like "+name:toto age:>30"
+func (c *Collection) SearchString(indexName, q string, opts SearchOptions) (*SearchResult, error) {
+	size := opts.Size
+	if size <= 0 {
+		size = 10
+	}
+
+	searchRequest := bleve.NewSearchRequestOptions(bleve.NewQueryStringQuery(q), size, opts.From, false)
+	if len(opts.Sort) != 0 {
+		searchRequest.SortBy(opts.Sort)
+	}
+	searchRequest.Fields = opts.Fields
+	for field, facetSize := range opts.Facets {
+		searchRequest.AddFacet(field, bleve.NewFacetRequest(field, facetSize))
+	}
+	if opts.Highlight != "" {
+		searchRequest.Highlight = bleve.NewHighlightWithStyle(opts.Highlight)
+	}
+
+	return c.SearchWithOptions(indexName, searchRequest)
+}
+
+func (s *SearchResult) collection(docMatch *search.DocumentMatch) *Collection {
+	if s.collections != nil {
+		return s.collections[docMatch.Index]
+	}
+	return s.c
+}
+
+// SearchAcross makes one search over many indexes which can be in different collections.
+// The scores are merged by bleve and every hit is loaded from its own collection.
+// The indexes given many times are searched once. It returns ErrCollectionNotFound or
+// ErrIndexNotFound if a collection or an index does not exist and ErrNotFound if nothing matches.
+func (d *DB) SearchAcross(indexRefs []IndexRef, searchRequest *bleve.SearchRequest) (*SearchResult, error) {
+	ret := &SearchResult{
+		collections: map[string]*Collection{},
+	}
+
+	alias := bleve.NewIndexAlias()
+	added := map[IndexRef]bool{}
+	for _, ref := range indexRefs {
+		if added[ref] {
+			continue
+		}
+		added[ref] = true
+
+		col, err := d.getCollection(ref.Collection)
+		if err == ErrNotFound {
+			return nil, ErrCollectionNotFound
+		} else if err != nil {
+			return nil, err
+		}
+
+		index, err := col.GetBleveIndex(ref.Index)
+		if err != nil {
+			return nil, err
+		}
+
+		alias.Add(index.bleveIndex)
+		ret.collections[index.bleveIndex.Name()] = col
+	}
+
+	var err error
+	ret.BleveSearchResult, err = alias.Search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	if ret.BleveSearchResult.Hits.Len() == 0 {
+		return nil, ErrNotFound
+	}
+
+	return ret, nil
+}
+
+// SearchIterator returns an iterator over all the results of the search request.
+// The size of the request defines the size of the pages and the iterator goes on
+// until the last matching document. An empty result is not an error.
+func (c *Collection) SearchIterator(indexName string, searchRequest *bleve.SearchRequest) (*SearchIterator, error) {
+	index, err := c.GetBleveIndex(indexName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy the request to not change the caller's one
+	request := *searchRequest
+	if request.Size <= 0 {
+		request.Size = 10
+	}
+
+	ret := &SearchIterator{
+		c:       c,
+		index:   index,
+		request: &request,
+		from:    request.From,
+	}
+
+	err = ret.fetchPage()
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// Total returns the number of documents matching the query
+func (s *SearchIterator) Total() uint64 {
+	return s.total
+}
+
+// Next fills up the destination with the next document.
+// It returns ErrEndOfQueryResult when there is no more document.
+func (s *SearchIterator) Next(dest interface{}) (id string, err error) {
+	resp, err := s.NextResponse(dest)
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// NextResponse does the same as *SearchIterator.Next but returns the byte stream
+// and the bleve match of the document
+func (s *SearchIterator) NextResponse(dest interface{}) (*Response, error) {
+	for {
+		if s.position >= len(s.hits) {
+			if s.lastPage {
+				return nil, ErrEndOfQueryResult
+			}
+
+			err := s.fetchPage()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		docMatch := s.hits[s.position]
+		content := s.contents[s.position]
+		s.position++
+
+		// The document was removed after being indexed
+		if content == nil {
+			continue
+		}
+
+		if dest != nil {
+			codec, err := s.c.getCodec()
+			if err != nil {
+				return nil, err
+			}
+			err = codec.Unmarshal(content, dest)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return &Response{
+			ID:            docMatch.ID,
+			Content:       content,
+			DocumentMatch: docMatch,
+		}, nil
+	}
+}
+
+// fetchPage runs the search for the next page and loads the documents
+func (s *SearchIterator) fetchPage() error {
+	s.request.From = s.from
+
+	result, err := s.index.bleveIndex.Search(s.request)
+	if err != nil {
+		return err
+	}
+
+	s.total = result.Total
+	s.hits = result.Hits
+	s.position = 0
+	s.from += len(result.Hits)
+	s.lastPage = len(result.Hits) < s.request.Size
+
+	ids := make([]string, len(s.hits))
+	for i, hit := range s.hits {
+		ids[i] = hit.ID
+	}
+
+	s.contents, err = s.c.GetMulti(ids, make([]interface{}, len(ids)))
+	if err == nil {
+		return nil
+	}
+
+	// Some documents are missing, they are loaded one by one
+	s.contents = make([][]byte, len(ids))
+	for i, id := range ids {
+		s.contents[i], err = s.c.Get(id, nil)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
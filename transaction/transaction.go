@@ -2,6 +2,8 @@ package transaction
 
 import (
 	"context"
+
+	"github.com/dgraph-io/badger"
 )
 
 type (
@@ -21,6 +23,20 @@ type (
 
 		DBKey, Value         []byte
 		Delete, CleanHistory bool
+
+		// Compressible values start with a header byte which gives the algorithm used
+		// to compress the rest of the value. It's set for the documents of the collections.
+		Compressible bool
+		// Compression defines the algorithm used to compress the value before encryption
+		Compression      byte
+		CompressionLevel int
+
+		// Hook is called by the writer inside the same database transaction once the
+		// operation is written. It keeps the secondary indexes in the same commit.
+		Hook func(txn *badger.Txn) error
+		// Check is called by the writer once all operations of the transaction are written.
+		// If it returns an error nothing of the transaction is committed.
+		Check func(txn *badger.Txn) error
 	}
 )
 
@@ -72,3 +88,28 @@ func (t *Transaction) GetWriteSize() (ret int) {
 	}
 	return
 }
+
+// HasChecks returns true if any operation of the transaction needs to be checked before the commit
+func (t *Transaction) HasChecks() bool {
+	for _, op := range t.Operations {
+		if op.Check != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// RunChecks calls the checks of the operations and returns the first error
+func (t *Transaction) RunChecks(txn *badger.Txn) error {
+	for _, op := range t.Operations {
+		if op.Check == nil {
+			continue
+		}
+
+		err := op.Check(txn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package gotinydb
+
+import (
+	"context"
+
+	"github.com/alexandrestein/gotinydb/transaction"
+	"github.com/dgraph-io/badger"
+)
+
+type (
+	// Tx is a transaction over many collections. It is provided by *DB.Update and *DB.View.
+	// The reads done inside the transaction see the writes done before in the same transaction.
+	// All writes are committed atomically when the function returns without error.
+	Tx struct {
+		ctx    context.Context
+		db     *DB
+		txn    *badger.Txn
+		update bool
+
+		// batches keeps the operations by collection to update the indexes and call the hooks
+		// once the commit is done
+		batches []*Batch
+		// journals keeps the index journal entries by collection to clean them up
+		// once the indexes are done
+		journals map[*Collection][]*transaction.Operation
+	}
+
+	// TxCollection gives access to a collection inside a transaction
+	TxCollection struct {
+		tx    *Tx
+		c     *Collection
+		batch *Batch
+	}
+)
+
+// Update runs the given function inside a read-write transaction.
+// If the function returns an error nothing is written. If an other write updated
+// a document read during the transaction or saved a value of a unique index written by
+// the transaction, ErrConflict is returned and the function needs to be run again.
+// The indexes are updated once the commit is done.
+func (d *DB) Update(fn func(tx *Tx) error) error {
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+
+	tx := &Tx{
+		ctx:      ctx,
+		db:       d,
+		update:   true,
+		journals: map[*Collection][]*transaction.Operation{},
+	}
+
+	tx.txn = d.badger.NewTransaction(true)
+	defer tx.txn.Discard()
+
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.commit()
+	if err != nil {
+		if err == badger.ErrConflict {
+			return ErrConflict
+		}
+		return err
+	}
+
+	for _, batch := range tx.batches {
+		err = batch.c.indexAfterWrite(batch.tr, tx.journals[batch.c])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commit runs the checks and commits the transaction. It's done with the lock of the writer
+// to not let an other write be committed between the checks and the commit.
+// The checks read the unique values with their keys to make badger detect the conflicts
+// with the writes committed since the beginning of the transaction.
+func (tx *Tx) commit() error {
+	tx.db.commitLock.Lock()
+	defer tx.db.commitLock.Unlock()
+
+	// The checks are done once all writes are done
+	for _, batch := range tx.batches {
+		err := batch.tr.RunChecks(tx.txn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.txn.Commit(nil)
+}
+
+// View runs the given function inside a read-only transaction.
+// All reads see the database as it was when the transaction started.
+func (d *DB) View(fn func(tx *Tx) error) error {
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+
+	tx := &Tx{
+		ctx: ctx,
+		db:  d,
+	}
+
+	return d.badger.View(func(txn *badger.Txn) error {
+		tx.txn = txn
+		return fn(tx)
+	})
+}
+
+// Collection returns the collection with the given name inside the transaction.
+// The collection must already exist, ErrCollectionNotFound is returned otherwise.
+func (tx *Tx) Collection(name string) (*TxCollection, error) {
+	col, err := tx.db.getCollection(name)
+	if err == ErrNotFound {
+		return nil, ErrCollectionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Only one batch per collection
+	for _, batch := range tx.batches {
+		if batch.c == col {
+			return &TxCollection{tx: tx, c: col, batch: batch}, nil
+		}
+	}
+
+	batch, err := col.NewBatch(tx.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tx.update {
+		tx.batches = append(tx.batches, batch)
+	}
+
+	return &TxCollection{tx: tx, c: col, batch: batch}, nil
+}
+
+// Get returns the document as it is inside the transaction.
+// It fills up the given dest pointer if provided.
+func (tc *TxCollection) Get(id string, dest interface{}) (contentAsBytes []byte, err error) {
+	contentAsBytes, err = tc.c.get(tc.tx.txn, id, dest)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	}
+	return
+}
+
+// Put sets the document inside the transaction
+func (tc *TxCollection) Put(id string, content interface{}) error {
+	return tc.write(id, content, false)
+}
+
+// Delete removes the document inside the transaction
+func (tc *TxCollection) Delete(id string) error {
+	return tc.write(id, nil, true)
+}
+
+func (tc *TxCollection) write(id string, content interface{}, delete bool) (err error) {
+	if !tc.tx.update {
+		return ErrReadOnlyTx
+	}
+
+	// The batch runs the hooks and builds the operation
+	if delete {
+		err = tc.batch.Delete(id)
+	} else {
+		err = tc.batch.Put(id, content)
+	}
+	if err != nil {
+		return err
+	}
+
+	op := tc.batch.tr.Operations[len(tc.batch.tr.Operations)-1]
+	err = tc.tx.db.writeOperation(tc.tx.txn, op)
+	if err != nil {
+		return err
+	}
+
+	// The index journal is committed with the document
+	journal := tc.c.buildIndexJournalOperations([]*transaction.Operation{op})
+	for _, journalOp := range journal {
+		err = tc.tx.db.writeOperation(tc.tx.txn, journalOp)
+		if err != nil {
+			return err
+		}
+	}
+	tc.tx.journals[tc.c] = append(tc.tx.journals[tc.c], journal...)
+
+	return nil
+}
+
+// GetIterator returns an iterator which lists the documents inside the transaction.
+// Badger allows only one iterator at a time inside a read-write transaction.
+// The iterator must be closed before the end of the transaction.
+func (tc *TxCollection) GetIterator() *CollectionIterator {
+	iter := tc.c.getIteratorWithTxn(tc.tx.txn, false, true)
+	iter.badgerIter.Seek(iter.colPrefix)
+	return iter
+}
+
+// GetRevertedIterator does the same as *TxCollection.GetIterator in the oposite order
+func (tc *TxCollection) GetRevertedIterator() *CollectionIterator {
+	iter := tc.c.getIteratorWithTxn(tc.tx.txn, true, true)
+	iter.badgerIter.Seek(tc.c.buildJustTooBigDBPrefix())
+	return iter
+}
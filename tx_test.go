@@ -0,0 +1,167 @@
+package gotinydb
+
+import (
+	"fmt"
+	"testing"
+)
+
+type testAccount struct {
+	Balance int `json:"balance"`
+}
+
+func TestTransactions(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	var savings *Collection
+	savings, err = testDB.Use("savings")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = testCol.Put("account", &testAccount{100})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = savings.Put("account", &testAccount{0})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	transfer := func(amount int) error {
+		return testDB.Update(func(tx *Tx) error {
+			from, err := tx.Collection(testColName)
+			if err != nil {
+				return err
+			}
+			to, err := tx.Collection("savings")
+			if err != nil {
+				return err
+			}
+
+			fromAccount, toAccount := new(testAccount), new(testAccount)
+			_, err = from.Get("account", fromAccount)
+			if err != nil {
+				return err
+			}
+			_, err = to.Get("account", toAccount)
+			if err != nil {
+				return err
+			}
+
+			fromAccount.Balance -= amount
+			toAccount.Balance += amount
+
+			err = from.Put("account", fromAccount)
+			if err != nil {
+				return err
+			}
+			err = to.Put("account", toAccount)
+			if err != nil {
+				return err
+			}
+
+			// Read your writes
+			check := new(testAccount)
+			_, err = from.Get("account", check)
+			if err != nil {
+				return err
+			}
+			if check.Balance != fromAccount.Balance {
+				return fmt.Errorf("the transaction must read its own writes")
+			}
+
+			if fromAccount.Balance < 0 {
+				return fmt.Errorf("not enough money")
+			}
+			return nil
+		})
+	}
+
+	err = transfer(60)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// This must fail and nothing must be written
+	err = transfer(60)
+	if err == nil {
+		t.Errorf("the transfer must fail")
+		return
+	}
+
+	checkBalances := func(expectedFrom, expectedTo int) {
+		fromAccount, toAccount := new(testAccount), new(testAccount)
+		testCol.Get("account", fromAccount)
+		savings.Get("account", toAccount)
+		if fromAccount.Balance != expectedFrom || toAccount.Balance != expectedTo {
+			t.Errorf("expected balances %d and %d but got %d and %d", expectedFrom, expectedTo, fromAccount.Balance, toAccount.Balance)
+		}
+	}
+	checkBalances(40, 60)
+
+	// A write done outside of the transaction on a read document must give a conflict
+	err = testDB.Update(func(tx *Tx) error {
+		col, err := tx.Collection(testColName)
+		if err != nil {
+			return err
+		}
+
+		account := new(testAccount)
+		_, err = col.Get("account", account)
+		if err != nil {
+			return err
+		}
+
+		err = testCol.Put("account", &testAccount{1000})
+		if err != nil {
+			return err
+		}
+
+		account.Balance++
+		return col.Put("account", account)
+	})
+	if err != ErrConflict {
+		t.Errorf("expected %v but got %v", ErrConflict, err)
+		return
+	}
+	checkBalances(1000, 60)
+
+	err = testDB.View(func(tx *Tx) error {
+		col, err := tx.Collection("savings")
+		if err != nil {
+			return err
+		}
+
+		iter := col.GetIterator()
+		defer iter.Close()
+		n := 0
+		for ; iter.Valid(); iter.Next() {
+			n++
+		}
+		if n != 1 {
+			return fmt.Errorf("expected 1 document but got %d", n)
+		}
+
+		if col.Delete("account") != ErrReadOnlyTx {
+			return fmt.Errorf("a read only transaction can't delete")
+		}
+
+		_, err = tx.Collection("does not exist")
+		if err != ErrCollectionNotFound {
+			return fmt.Errorf("expected %v but got %v", ErrCollectionNotFound, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -7,12 +7,17 @@ const (
 	prefixConfig byte = iota
 	prefixCollections
 	prefixFiles
+	prefixRefs
+	prefixSequences
 )
 
 // Those constants defines the second level of prefixes or value from config.
 const (
 	prefixCollectionsData byte = iota
 	prefixCollectionsBleveIndex
+	prefixCollectionsIndexJournal
+	prefixCollectionsOrderedIndex
+	prefixCollectionsView
 )
 
 // This defines most of the package errors
@@ -21,8 +26,19 @@ var (
 	ErrHashCollision      = fmt.Errorf("the name is in collision with an other element")
 	ErrEmptyID            = fmt.Errorf("ID must be provided")
 	ErrIndexNotFound      = fmt.Errorf("index not found")
+	ErrCollectionNotFound = fmt.Errorf("collection not found")
 	ErrNameAllreadyExists = fmt.Errorf("element with the same name allready exists")
 	ErrGetMultiNotEqual   = fmt.Errorf("you must provied the same number of ids and destinations")
+	ErrCodecNotFound      = fmt.Errorf("codec not registered")
+	ErrCollectionNotEmpty = fmt.Errorf("the collection must be empty")
+	ErrConflict           = fmt.Errorf("the transaction is in conflict with an other write and needs to be retried")
+	ErrReadOnlyTx         = fmt.Errorf("the transaction is read only")
+	ErrIndexValueType     = fmt.Errorf("the value type can't be indexed")
+	ErrUniqueViolation    = fmt.Errorf("an other document already has the value of the unique index")
+	ErrInvalidCursor      = fmt.Errorf("the cursor is not valid for this listing")
+	ErrIntegrity          = fmt.Errorf("the content can't be decrypted, it is corrupted or it was modified")
+	ErrReferenced         = fmt.Errorf("the document is referenced by an other document")
+	ErrIndexNotReady      = fmt.Errorf("the function of the index or of the view must be set again after the opening of the database")
 
 	ErrEndOfQueryResult = fmt.Errorf("there is no more values to retrieve from the query")
 
@@ -0,0 +1,752 @@
+package gotinydb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+
+	"github.com/alexandrestein/gotinydb/transaction"
+	"github.com/dgraph-io/badger"
+	"golang.org/x/crypto/blake2b"
+)
+
+type (
+	// View is a map/reduce view of a collection saved in the database.
+	// The rows emitted by the map function are updated in the same commit as the documents
+	// and the reduce function is applied to the rows when the view is queried.
+	View struct {
+		dbElement
+		// Reduced is true if the view has a reduce function. It's public for marshalling
+		// reason and should never be used directly.
+		Reduced bool
+
+		c        *Collection
+		mapFn    ViewMapFunc
+		reduceFn ViewReduceFunc
+	}
+
+	// ViewMapFunc is called for every document and adds rows to the view by calling emit.
+	// The document is decoded with the ToIndex function of the codec of the collection,
+	// it's a map for the JSON documents.
+	// The keys can be strings, numbers, booleans or time.Time like the values of the ordered
+	// indexes and the values are saved as JSON.
+	ViewMapFunc func(id string, document interface{}, emit func(key, value interface{}))
+
+	// ViewReduceFunc reduces the values of the rows having the same key
+	ViewReduceFunc func(key interface{}, values []interface{}) interface{}
+
+	// ViewRow is a row returned by *View.Query and *View.Rows.
+	// ID is empty if the row is the reduction of the rows of the key.
+	ViewRow struct {
+		Key   interface{}
+		ID    string
+		Value interface{}
+	}
+
+	// KeyRange selects the rows of a view by key.
+	// Both bounds are included and a nil bound means no limit.
+	KeyRange struct {
+		From, To interface{}
+	}
+
+	// savedViewRow is the content of a row as it's saved
+	savedViewRow struct {
+		Key   interface{} `json:"k"`
+		Value interface{} `json:"v"`
+	}
+)
+
+// Those constants separate the rows from the references of the documents,
+// from the reduced values of the keys and from the lists of the rows of the keys
+const (
+	viewRows byte = iota
+	viewReferences
+	viewReduced
+	viewKeyRows
+)
+
+// ReduceCount is a ViewReduceFunc which counts the rows
+func ReduceCount(key interface{}, values []interface{}) interface{} {
+	return float64(len(values))
+}
+
+// ReduceSum is a ViewReduceFunc which adds up the values, the values which are not numbers are ignored
+func ReduceSum(key interface{}, values []interface{}) interface{} {
+	sum := 0.0
+	for _, value := range values {
+		if number, ok := numberValue(value); ok {
+			sum += number
+		}
+	}
+	return sum
+}
+
+// DefineView adds a view to the collection or gives back the functions of an existing one.
+// Like the selectors of the ordered indexes, the functions are not saved and they need to be
+// set again after every opening of the database and before any write. Until then the writes
+// of documents fail with ErrIndexNotReady.
+// The existing documents are mapped when the view is created and mapped again every time
+// the functions are set, the functions can be different.
+// The reduce function is optional. The reduced values are saved by key and computed again
+// from the rows of the key when they change, the reduce function should be fast for the keys
+// having many rows.
+// The views which are not used anymore are removed with *Collection.DeleteView.
+func (c *Collection) DefineView(name string, mapFn ViewMapFunc, reduceFn ViewReduceFunc) error {
+	for _, view := range c.getViews() {
+		if view.Name == name {
+			view.c = c
+			err := view.remap(mapFn, reduceFn)
+			if err != nil {
+				return err
+			}
+			return c.db.saveConfig()
+		}
+	}
+
+	viewHash := blake2b.Sum256([]byte(name))
+	prefix := make([]byte, len(c.Prefix), len(c.Prefix)+3)
+	copy(prefix, c.Prefix)
+	prefix = append(prefix, prefixCollectionsView)
+	prefix = append(prefix, viewHash[:2]...)
+
+	for _, view := range c.getViews() {
+		if reflect.DeepEqual(view.Prefix, prefix) {
+			return ErrHashCollision
+		}
+	}
+
+	view := &View{
+		dbElement: dbElement{
+			Name:   name,
+			Prefix: prefix,
+		},
+		c:        c,
+		mapFn:    mapFn,
+		reduceFn: reduceFn,
+	}
+
+	err := c.addView(view)
+	if err != nil {
+		return err
+	}
+
+	err = view.reduceAll(mapFn, reduceFn)
+	if err != nil {
+		return err
+	}
+
+	return c.db.saveConfig()
+}
+
+// GetView returns the view with the given name
+func (c *Collection) GetView(name string) (*View, error) {
+	for _, view := range c.getViews() {
+		if view.Name == name {
+			view.c = c
+			return view, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// DeleteView removes the view and its rows. Like the functions, the views which are not used
+// anymore must be deleted, otherwise the writes fail with ErrIndexNotReady after the next
+// opening of the database.
+func (c *Collection) DeleteView(name string) error {
+	var view *View
+
+	// The hooks are not running while the view and its rows are removed
+	c.db.commitLock.Lock()
+	defer c.db.commitLock.Unlock()
+
+	c.viewsLock.Lock()
+	for i, tmpView := range c.Views {
+		if tmpView.Name == name {
+			view = tmpView
+
+			// The list is copied because it can be read without the lock
+			views := make([]*View, 0, len(c.Views)-1)
+			views = append(views, c.Views[:i]...)
+			c.Views = append(views, c.Views[i+1:]...)
+
+			break
+		}
+	}
+	c.viewsLock.Unlock()
+
+	if view == nil {
+		return ErrNotFound
+	}
+
+	ops, err := c.db.prefixDeleteOperations(view.Prefix)
+	if err != nil {
+		return err
+	}
+	err = c.db.writeOperationsLocked(ops)
+	if err != nil {
+		return err
+	}
+
+	return c.db.saveConfig()
+}
+
+// Query returns the rows with a key in the range. If the view has a reduce function
+// it returns the saved reductions of the rows of the keys, one row per key.
+// The rows are ordered by key.
+// It returns ErrIndexNotReady if the view has a reduce function which is not set again
+// since the opening of the database.
+func (v *View) Query(keyRange KeyRange) ([]*ViewRow, error) {
+	if v.reduceFn == nil {
+		if v.Reduced {
+			return nil, ErrIndexNotReady
+		}
+		return v.Rows(keyRange)
+	}
+
+	ret := []*ViewRow{}
+	err := v.scan(viewReduced, keyRange, func(row *ViewRow) error {
+		ret = append(ret, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Rows returns the rows with a key in the range without reducing them.
+// The rows are ordered by key and by id.
+func (v *View) Rows(keyRange KeyRange) ([]*ViewRow, error) {
+	ret := []*ViewRow{}
+	err := v.scan(viewRows, keyRange, func(row *ViewRow) error {
+		ret = append(ret, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// scan calls fn for every row or reduced value of the range
+func (v *View) scan(kind byte, keyRange KeyRange, fn func(row *ViewRow) error) (err error) {
+	var from, to []byte
+	if keyRange.From != nil {
+		from, err = encodeOrderedValue(keyRange.From)
+		if err != nil {
+			return err
+		}
+	}
+	if keyRange.To != nil {
+		to, err = encodeOrderedValue(keyRange.To)
+		if err != nil {
+			return err
+		}
+	}
+
+	prefix := v.buildKeyPrefix(kind)
+	return v.c.db.badger.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		for iter.Seek(append(prefix, from...)); iter.ValidForPrefix(prefix); iter.Next() {
+			item := iter.Item()
+			if item.IsDeletedOrExpired() {
+				continue
+			}
+
+			entry := item.KeyCopy(nil)[len(prefix):]
+			n := orderedValueLen(entry)
+			if !inOrderedRange(entry[:n], from, to) {
+				return nil
+			}
+
+			clearBytes, err := v.c.db.decryptItem(item)
+			if err != nil {
+				return err
+			}
+
+			saved := new(savedViewRow)
+			err = json.Unmarshal(clearBytes, saved)
+			if err != nil {
+				return err
+			}
+
+			row := &ViewRow{
+				Key:   saved.Key,
+				Value: saved.Value,
+			}
+			if kind == viewRows {
+				// The id is followed by the position of the row in the rows of the document
+				row.ID = string(entry[n : len(entry)-4])
+			}
+
+			err = fn(row)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// buildViewsHook returns the function which updates the views inside the write transaction of the document.
+// The views are read again by the hook to also update the views added since the operation was built.
+func (c *Collection) buildViewsHook(id string, document *indexedDocument, delete bool) (func(txn *badger.Txn) error, error) {
+	keys := map[*View][][]byte{}
+	values := map[*View][][]byte{}
+	if !delete {
+		for _, view := range c.getViews() {
+			// The view would not be updated without map function
+			if view.mapFn == nil {
+				return nil, ErrIndexNotReady
+			}
+
+			decoded, err := document.get()
+			if err != nil {
+				return nil, err
+			}
+			keys[view], values[view], err = view.mapDocument(id, decoded)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return func(txn *badger.Txn) error {
+		for _, view := range c.getViews() {
+			if _, found := keys[view]; !found {
+				decoded, err := document.get()
+				if err != nil {
+					return err
+				}
+				keys[view], values[view], err = view.mapDocument(id, decoded)
+				if err != nil {
+					return err
+				}
+			}
+
+			err := c.db.updateView(txn, view, id, keys[view], values[view])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// updateView removes the old rows of the document and adds the new ones
+func (d *DB) updateView(txn *badger.Txn, view *View, id string, keys, values [][]byte) error {
+	refKey := view.buildReferenceKey(id)
+	// oldKeys are the keys of the rows removed
+	var oldKeys [][]byte
+
+	item, err := txn.Get(refKey)
+	if err == nil {
+		var refValue []byte
+		refValue, err = d.decryptItem(item)
+		if err != nil {
+			return err
+		}
+
+		err = json.Unmarshal(refValue, &oldKeys)
+		if err != nil {
+			return err
+		}
+
+		for _, rowKey := range oldKeys {
+			err = txn.Delete(rowKey)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(keys) == 0 {
+			err = txn.Delete(refKey)
+			if err != nil {
+				return err
+			}
+		}
+	} else if err != badger.ErrKeyNotFound {
+		return err
+	}
+
+	ops, err := view.buildOperations(id, keys, values)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		err = d.writeOperation(txn, op)
+		if err != nil {
+			return err
+		}
+	}
+
+	if view.reduceFn == nil {
+		return nil
+	}
+
+	// Only the reduced values of the changed keys are computed again
+	removed := map[string][][]byte{}
+	added := map[string][][]byte{}
+	changedKeys := [][]byte{}
+	rowsPrefix := view.buildKeyPrefix(viewRows)
+	groupByKey := func(rowKeys [][]byte, rows map[string][][]byte) {
+		for _, rowKey := range rowKeys {
+			entry := rowKey[len(rowsPrefix):]
+			encodedKey := entry[:orderedValueLen(entry)]
+			if _, found := removed[string(encodedKey)]; !found {
+				if _, found := added[string(encodedKey)]; !found {
+					changedKeys = append(changedKeys, encodedKey)
+				}
+			}
+			rows[string(encodedKey)] = append(rows[string(encodedKey)], rowKey)
+		}
+	}
+	groupByKey(oldKeys, removed)
+	groupByKey(keys, added)
+
+	for _, encodedKey := range changedKeys {
+		err = d.reduceViewKey(txn, view, encodedKey, removed[string(encodedKey)], added[string(encodedKey)])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reduceViewKey updates the list of the rows of the key and saves the reduction of the rows
+// or removes it if the key has no row.
+// The rows are read by key from the list, this way no iterator is opened in the write transaction.
+func (d *DB) reduceViewKey(txn *badger.Txn, view *View, encodedKey []byte, removed, added [][]byte) error {
+	listKey := view.buildKeyRowsKey(encodedKey)
+
+	var rowKeys [][]byte
+	item, err := txn.Get(listKey)
+	if err == nil {
+		var clearBytes []byte
+		clearBytes, err = d.decryptItem(item)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(clearBytes, &rowKeys)
+		if err != nil {
+			return err
+		}
+	} else if err != badger.ErrKeyNotFound {
+		return err
+	}
+
+	isRemoved := map[string]bool{}
+	for _, rowKey := range removed {
+		isRemoved[string(rowKey)] = true
+	}
+	newRowKeys := [][]byte{}
+	for _, rowKey := range rowKeys {
+		if !isRemoved[string(rowKey)] {
+			newRowKeys = append(newRowKeys, rowKey)
+		}
+	}
+	newRowKeys = append(newRowKeys, added...)
+
+	reducedKey := view.buildReducedKey(encodedKey)
+	if len(newRowKeys) == 0 {
+		err = txn.Delete(listKey)
+		if err != nil {
+			return err
+		}
+		return txn.Delete(reducedKey)
+	}
+
+	var key interface{}
+	values := []interface{}{}
+	for _, rowKey := range newRowKeys {
+		item, err := txn.Get(rowKey)
+		if err != nil {
+			return err
+		}
+
+		clearBytes, err := d.decryptItem(item)
+		if err != nil {
+			return err
+		}
+
+		saved := new(savedViewRow)
+		err = json.Unmarshal(clearBytes, saved)
+		if err != nil {
+			return err
+		}
+
+		key = saved.Key
+		values = append(values, saved.Value)
+	}
+
+	listValue, err := json.Marshal(newRowKeys)
+	if err != nil {
+		return err
+	}
+	reduced, err := json.Marshal(&savedViewRow{Key: key, Value: view.reduceFn(key, values)})
+	if err != nil {
+		return err
+	}
+
+	err = d.writeOperation(txn, transaction.NewOperation("", nil, listKey, listValue, false, true))
+	if err != nil {
+		return err
+	}
+	return d.writeOperation(txn, transaction.NewOperation("", nil, reducedKey, reduced, false, true))
+}
+
+// reduceAll computes again the reduced values of all keys of the view.
+// It's done when the functions are set because the reduce function can be different.
+// The other writes wait until it's done.
+func (v *View) reduceAll(mapFn ViewMapFunc, reduceFn ViewReduceFunc) error {
+	v.c.db.commitLock.Lock()
+	defer v.c.db.commitLock.Unlock()
+
+	v.mapFn = mapFn
+	v.reduceFn = reduceFn
+	v.Reduced = reduceFn != nil
+
+	return v.reduceAllLocked()
+}
+
+// remap removes the rows of the view and maps again the existing documents with the given
+// functions, then it computes again the reduced values.
+// The other writes wait until it's done. If an error occurs the previous functions are kept.
+func (v *View) remap(mapFn ViewMapFunc, reduceFn ViewReduceFunc) error {
+	codec, err := v.c.getCodec()
+	if err != nil {
+		return err
+	}
+
+	v.c.db.commitLock.Lock()
+	defer v.c.db.commitLock.Unlock()
+
+	// The rows, the references, the reduced values and the lists of rows are removed
+	ops, err := v.c.db.prefixDeleteOperations(v.Prefix)
+	if err != nil {
+		return err
+	}
+
+	mapFnBefore, reduceFnBefore, reducedBefore := v.mapFn, v.reduceFn, v.Reduced
+	v.mapFn = mapFn
+	v.reduceFn = reduceFn
+	v.Reduced = reduceFn != nil
+
+	mapOps, err := v.c.existingDocumentsOperations(codec, func(id string, document interface{}) ([]*transaction.Operation, error) {
+		keys, values, err := v.mapDocument(id, document)
+		if err != nil {
+			return nil, err
+		}
+
+		return v.buildOperations(id, keys, values)
+	})
+	if err == nil {
+		err = v.c.db.writeOperationsLocked(append(ops, mapOps...))
+	}
+	if err == nil {
+		err = v.reduceAllLocked()
+	}
+	if err != nil {
+		v.mapFn, v.reduceFn, v.Reduced = mapFnBefore, reduceFnBefore, reducedBefore
+		return err
+	}
+
+	return nil
+}
+
+// reduceAllLocked does the work of *View.reduceAll once the commits are locked
+func (v *View) reduceAllLocked() error {
+	ops := []*transaction.Operation{}
+	err := v.c.db.badger.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		// The old reduced values and lists of rows are removed
+		for _, kind := range []byte{viewReduced, viewKeyRows} {
+			prefix := v.buildKeyPrefix(kind)
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+				ops = append(ops, transaction.NewOperation("", nil, iter.Item().KeyCopy(nil), nil, true, false))
+			}
+		}
+
+		if v.reduceFn == nil {
+			return nil
+		}
+
+		var lastKey []byte
+		var key interface{}
+		values := []interface{}{}
+		rowKeys := [][]byte{}
+		reduce := func() error {
+			if lastKey == nil {
+				return nil
+			}
+			listValue, err := json.Marshal(rowKeys)
+			if err != nil {
+				return err
+			}
+			reduced, err := json.Marshal(&savedViewRow{Key: key, Value: v.reduceFn(key, values)})
+			if err != nil {
+				return err
+			}
+			ops = append(ops,
+				transaction.NewOperation("", nil, v.buildKeyRowsKey(lastKey), listValue, false, true),
+				transaction.NewOperation("", nil, v.buildReducedKey(lastKey), reduced, false, true),
+			)
+			return nil
+		}
+
+		rowsPrefix := v.buildKeyPrefix(viewRows)
+		for iter.Seek(rowsPrefix); iter.ValidForPrefix(rowsPrefix); iter.Next() {
+			item := iter.Item()
+			if item.IsDeletedOrExpired() {
+				continue
+			}
+
+			rowKey := item.KeyCopy(nil)
+			entry := rowKey[len(rowsPrefix):]
+			encodedKey := entry[:orderedValueLen(entry)]
+
+			clearBytes, err := v.c.db.decryptItem(item)
+			if err != nil {
+				return err
+			}
+			saved := new(savedViewRow)
+			err = json.Unmarshal(clearBytes, saved)
+			if err != nil {
+				return err
+			}
+
+			if !bytes.Equal(lastKey, encodedKey) {
+				err = reduce()
+				if err != nil {
+					return err
+				}
+				lastKey = encodedKey
+				key = saved.Key
+				values = []interface{}{}
+				rowKeys = [][]byte{}
+			}
+			values = append(values, saved.Value)
+			rowKeys = append(rowKeys, rowKey)
+		}
+
+		return reduce()
+	})
+	if err != nil {
+		return err
+	}
+
+	return v.c.db.writeOperationsLocked(ops)
+}
+
+// addView adds the view and maps the existing documents.
+// The other writes wait until it's done.
+func (c *Collection) addView(view *View) error {
+	// The list is copied because it can be read without the lock
+	add := func() {
+		c.viewsLock.Lock()
+		c.Views = append(c.Views[:len(c.Views):len(c.Views)], view)
+		c.viewsLock.Unlock()
+	}
+	remove := func() {
+		c.viewsLock.Lock()
+		c.Views = c.Views[:len(c.Views)-1 : len(c.Views)-1]
+		c.viewsLock.Unlock()
+	}
+
+	return c.backfill(add, remove, func(id string, document interface{}) ([]*transaction.Operation, error) {
+		keys, values, err := view.mapDocument(id, document)
+		if err != nil {
+			return nil, err
+		}
+
+		return view.buildOperations(id, keys, values)
+	})
+}
+
+// mapDocument calls the map function and returns the keys and the contents of the rows
+func (v *View) mapDocument(id string, document interface{}) (keys, values [][]byte, err error) {
+	if v.mapFn == nil || document == nil {
+		return nil, nil, nil
+	}
+
+	v.mapFn(id, document, func(key, value interface{}) {
+		if err != nil {
+			return
+		}
+
+		var encodedKey, row []byte
+		encodedKey, err = encodeOrderedValue(key)
+		if err != nil {
+			return
+		}
+		row, err = json.Marshal(&savedViewRow{Key: key, Value: value})
+		if err != nil {
+			return
+		}
+
+		keys = append(keys, v.buildRowKey(encodedKey, id, len(keys)))
+		values = append(values, row)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return keys, values, nil
+}
+
+// buildOperations returns the operations which save the rows and the reference
+// of the document to find them back
+func (v *View) buildOperations(id string, keys, values [][]byte) ([]*transaction.Operation, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	refValue, err := json.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []*transaction.Operation{
+		transaction.NewOperation(id, nil, v.buildReferenceKey(id), refValue, false, true),
+	}
+	for i, key := range keys {
+		ops = append(ops, transaction.NewOperation(id, nil, key, values[i], false, true))
+	}
+	return ops, nil
+}
+
+func (v *View) buildKeyPrefix(kind byte) []byte {
+	prefix := make([]byte, len(v.Prefix), len(v.Prefix)+1)
+	copy(prefix, v.Prefix)
+	return append(prefix, kind)
+}
+
+// buildRowKey returns the key of the row. The position of the row makes the keys unique
+// if the same key is emitted many times for a document.
+func (v *View) buildRowKey(encodedKey []byte, id string, position int) []byte {
+	key := v.buildKeyPrefix(viewRows)
+	key = append(key, encodedKey...)
+	key = append(key, id...)
+
+	positionAsBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(positionAsBytes, uint32(position))
+	return append(key, positionAsBytes...)
+}
+
+func (v *View) buildReferenceKey(id string) []byte {
+	return append(v.buildKeyPrefix(viewReferences), id...)
+}
+
+func (v *View) buildReducedKey(encodedKey []byte) []byte {
+	return append(v.buildKeyPrefix(viewReduced), encodedKey...)
+}
+
+// buildKeyRowsKey returns the key of the list of the rows of the key
+func (v *View) buildKeyRowsKey(encodedKey []byte) []byte {
+	return append(v.buildKeyPrefix(viewKeyRows), encodedKey...)
+}
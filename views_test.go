@@ -0,0 +1,247 @@
+package gotinydb
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestViews(t *testing.T) {
+	defer clean()
+	err := open(t)
+	if err != nil {
+		return
+	}
+
+	var col *Collection
+	col, err = testDB.Use("products")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	put := func(id, category string, price float64) {
+		err := col.Put(id, map[string]interface{}{"category": category, "price": price})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Saved before the view is defined
+	put("p1", "books", 10)
+	put("p2", "books", 15)
+
+	byCategory := func(id string, document interface{}, emit func(key, value interface{})) {
+		asMap, ok := document.(map[string]interface{})
+		if !ok {
+			return
+		}
+		emit(asMap["category"], asMap["price"])
+	}
+
+	err = col.DefineView("byCategory", byCategory, ReduceSum)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	put("p3", "games", 40)
+	put("p4", "music", 5)
+	put("p5", "games", 20)
+
+	var view *View
+	view, err = col.GetView("byCategory")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	check := func(keyRange KeyRange, expected []*ViewRow) {
+		rows, err := view.Query(keyRange)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !reflect.DeepEqual(rows, expected) {
+			t.Errorf("expected %s but got %s", viewRowsString(expected), viewRowsString(rows))
+		}
+	}
+
+	check(KeyRange{}, []*ViewRow{
+		{Key: "books", Value: 25.0},
+		{Key: "games", Value: 60.0},
+		{Key: "music", Value: 5.0},
+	})
+	check(KeyRange{From: "c", To: "games"}, []*ViewRow{
+		{Key: "games", Value: 60.0},
+	})
+
+	// The rows follow the updates and the deletes
+	put("p1", "music", 10)
+	err = col.Delete("p3")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	check(KeyRange{}, []*ViewRow{
+		{Key: "books", Value: 15.0},
+		{Key: "games", Value: 20.0},
+		{Key: "music", Value: 15.0},
+	})
+
+	var rows []*ViewRow
+	rows, err = view.Rows(KeyRange{From: "music"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectedRows := []*ViewRow{
+		{Key: "music", ID: "p1", Value: 10.0},
+		{Key: "music", ID: "p4", Value: 5.0},
+	}
+	if !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("expected %s but got %s", viewRowsString(expectedRows), viewRowsString(rows))
+	}
+
+	// The view is saved and the map function is set again after opening
+	testDB.Close()
+	testDB, err = Open(testPath, testConfigKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	col, err = testDB.Use("products")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = col.Put("p7", map[string]interface{}{"category": "books", "price": 1})
+	if err != ErrIndexNotReady {
+		t.Errorf("expected %v but got %v", ErrIndexNotReady, err)
+		return
+	}
+	view, err = col.GetView("byCategory")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = view.Query(KeyRange{})
+	if err != ErrIndexNotReady {
+		t.Errorf("the view must not be queried without its reduce function, expected %v but got %v", ErrIndexNotReady, err)
+		return
+	}
+	// The reduced values are computed again with the new reduce function
+	err = col.DefineView("byCategory", byCategory, ReduceCount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	put("p6", "books", 1)
+
+	view, err = col.GetView("byCategory")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	check(KeyRange{}, []*ViewRow{
+		{Key: "books", Value: 2.0},
+		{Key: "games", Value: 1.0},
+		{Key: "music", Value: 2.0},
+	})
+
+	// The reduced values are saved by key
+	reducedPrefix := view.buildKeyPrefix(viewReduced)
+	nbReduced := 0
+	testDB.badger.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+		for iter.Seek(reducedPrefix); iter.ValidForPrefix(reducedPrefix); iter.Next() {
+			nbReduced++
+		}
+		return nil
+	})
+	if nbReduced != 3 {
+		t.Errorf("expected 3 reduced values but got %d", nbReduced)
+		return
+	}
+
+	// The reduced value is removed with the last row of the key
+	err = col.Delete("p5")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	check(KeyRange{}, []*ViewRow{
+		{Key: "books", Value: 2.0},
+		{Key: "music", Value: 2.0},
+	})
+
+	// The existing documents are mapped again when the view is redefined
+	byPrice := func(id string, document interface{}, emit func(key, value interface{})) {
+		asMap, ok := document.(map[string]interface{})
+		if !ok {
+			return
+		}
+		emit(asMap["price"], nil)
+	}
+	err = col.DefineView("byCategory", byPrice, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	check(KeyRange{}, []*ViewRow{
+		{Key: 1.0, ID: "p6"},
+		{Key: 5.0, ID: "p4"},
+		{Key: 10.0, ID: "p1"},
+		{Key: 15.0, ID: "p2"},
+	})
+
+	_, err = col.GetView("does not exist")
+	if err != ErrNotFound {
+		t.Errorf("expected %v but got %v", ErrNotFound, err)
+	}
+
+	// The deleted view has no row left and its functions are not needed after the opening
+	err = col.DeleteView("byCategory")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = col.DeleteView("byCategory")
+	if err != ErrNotFound {
+		t.Errorf("expected %v but got %v", ErrNotFound, err)
+		return
+	}
+	testDB.badger.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+		for iter.Seek(view.Prefix); iter.ValidForPrefix(view.Prefix); iter.Next() {
+			t.Errorf("the rows of the deleted view must be removed")
+			break
+		}
+		return nil
+	})
+
+	testDB.Close()
+	testDB, err = Open(testPath, testConfigKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	col, err = testDB.Use("products")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	put("p7", "books", 1)
+}
+
+func viewRowsString(rows []*ViewRow) string {
+	ret := ""
+	for _, row := range rows {
+		ret += fmt.Sprintf("%v ", *row)
+	}
+	return ret
+}